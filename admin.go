@@ -0,0 +1,318 @@
+package smartproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/ngaut/logging"
+)
+
+// ServeAdmin serves JSON admin API on admin port until proxy quits,
+// it is the same as PROXY command for tools speaking http
+//
+//	GET    /health              503 if draining
+//	GET    /info
+//	GET    /config?name=idletime
+//	POST   /config              name=idletime&value=200
+//	POST   /reload              reload config file
+//	GET    /sessions
+//	DELETE /sessions?addr=ip:port
+//	GET    /blacklist
+//	POST   /blacklist           key=name&ttl=3600
+//	DELETE /blacklist?key=name
+//	GET    /topology
+//	GET    /pools
+//	GET    /metrics             prometheus text format
+func (ps *ProxyServer) ServeAdmin() {
+	if ps.Conf.AdminPort == "" {
+		return
+	}
+
+	l, err := inheritedListener(adminFdEnv)
+	if err == nil && l == nil {
+		l, err = net.Listen("tcp4", "0.0.0.0:"+ps.Conf.AdminPort)
+	}
+	if err != nil {
+		log.Fatalf("Admin Server Listen on port : %s failed ", ps.Conf.AdminPort)
+	}
+	log.Info("Admin Server Listen on port ", ps.Conf.AdminPort)
+	ps.Lock.Lock()
+	ps.adminListen = l
+	ps.Lock.Unlock()
+
+	go http.Serve(l, ps.adminHandler())
+	<-ps.Quit
+	l.Close()
+	log.Warning("quit Admin Server")
+}
+
+func (ps *ProxyServer) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", ps.adminHealth)
+	mux.HandleFunc("/info", ps.adminInfo)
+	mux.HandleFunc("/config", ps.adminConfig)
+	mux.HandleFunc("/reload", ps.adminReload)
+	mux.HandleFunc("/sessions", ps.adminSessions)
+	mux.HandleFunc("/blacklist", ps.adminBlacklist)
+	mux.HandleFunc("/topology", ps.adminTopology)
+	mux.HandleFunc("/pools", ps.adminPools)
+	mux.HandleFunc("/metrics", ps.adminMetrics)
+	return mux
+}
+
+var errMethodNotAllowed = errors.New("method not allowed")
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warning("admin write response failed ", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func (ps *ProxyServer) adminHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+	if ps.Draining() {
+		writeError(w, http.StatusServiceUnavailable, ProxyDraining)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (ps *ProxyServer) adminInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+	info := map[string]interface{}{
+		"name":    ps.Conf.Name,
+		"id":      ps.Conf.Id,
+		"port":    ps.Conf.Port,
+		"statsd":  ps.Conf.Statsd,
+		"zk":      ps.Conf.Zk,
+		"zkpath":  ps.Conf.ZkPath,
+		"qps":     ps.LastQPS,
+		"conns":   ps.SessionCount(),
+		"uptime":  int64(time.Since(ps.Startup) / time.Second),
+		"backend": ps.Conf.BackendType,
+		"nodes":   ps.Backend.Nodes(),
+	}
+	if ps.Registry != nil {
+		info["registry"] = ps.Conf.RegistryType
+		info["proxies"] = ps.Proxies()
+	}
+	if c := ps.Cache; c != nil {
+		info["cache"] = c.Stats()
+	}
+	if c := ps.Coalescer; c != nil {
+		info["coalesce"] = c.Stats()
+	}
+	if m := ps.Mirror; m != nil {
+		info["mirror"] = map[string]interface{}{
+			"percent": m.Percent(),
+			"nodes":   ps.Conf.MirrorNodes,
+			"stats":   m.Stats(),
+		}
+	}
+	if m := ps.Migrate; m != nil {
+		info["migrate"] = map[string]interface{}{
+			"phase":     m.Phase(),
+			"secondary": m.Secondary.Nodes(),
+			"stats":     m.Stats(),
+		}
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (ps *ProxyServer) adminConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		name := strings.ToLower(r.FormValue("name"))
+		v, err := ps.ConfigGet(name)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": name, "value": v})
+	case "POST":
+		name := strings.ToLower(r.FormValue("name"))
+		value := strings.ToLower(r.FormValue("value"))
+		old, err := ps.ConfigSet(name, value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Warningf("admin set config %s to %s", name, value)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": name, "old": old})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+	}
+}
+
+func (ps *ProxyServer) adminReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+	changes, err := ps.ReloadConfig()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"changes": changes})
+}
+
+type adminSession struct {
+	Addr       string `json:"addr"`
+	LastAccess int64  `json:"last_access"` // unix seconds
+	Idle       int64  `json:"idle"`        // seconds
+}
+
+func (ps *ProxyServer) adminSessions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		now := time.Now().Unix()
+		sessions := make([]adminSession, 0)
+		for addr, s := range ps.Sessions() {
+			last := atomic.LoadInt64(&s.LastAccess) / 1e6
+			sessions = append(sessions, adminSession{Addr: addr, LastAccess: last, Idle: now - last})
+		}
+		writeJSON(w, http.StatusOK, sessions)
+	case "DELETE":
+		addr := r.FormValue("addr")
+		s, ok := ps.Sessions()[addr]
+		if !ok {
+			writeError(w, http.StatusNotFound, errors.New("session not exists"))
+			return
+		}
+		log.Warning("admin kill session ", addr)
+		// HandleConn removes it after read failed
+		s.Close()
+		writeJSON(w, http.StatusOK, map[string]string{"addr": addr})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+	}
+}
+
+type adminBlackKey struct {
+	Key      string `json:"key"`
+	Match    string `json:"match"`
+	Scope    string `json:"scope"`
+	Startup  int64  `json:"startup"`  // unix seconds
+	Deadline int64  `json:"deadline"` // unix seconds, 0 never expires
+	TTL      int64  `json:"ttl"`      // seconds, -1 never expires
+}
+
+func (ps *ProxyServer) adminBlacklist(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		now := time.Now()
+		keys := make([]adminBlackKey, 0)
+		for _, b := range ps.Blacklist.List() {
+			k := adminBlackKey{Key: b.Pattern, Match: b.Match, Scope: b.Scope, Startup: b.Startup.Unix(), TTL: -1}
+			if !b.Deadline.IsZero() {
+				k.Deadline = b.Deadline.Unix()
+				k.TTL = int64(b.TTL(now) / time.Second)
+			}
+			keys = append(keys, k)
+		}
+		writeJSON(w, http.StatusOK, keys)
+	case "POST":
+		key := r.FormValue("key")
+		if key == "" {
+			writeError(w, http.StatusBadRequest, WrongCommandKey)
+			return
+		}
+		ttl, err := strconv.Atoi(r.FormValue("ttl"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, BlackTimeUnavaliable)
+			return
+		}
+		match, scope := r.FormValue("match"), r.FormValue("scope")
+		if match == "" {
+			match = BlackExact
+		}
+		if scope == "" {
+			scope = BlackScopeAll
+		}
+		if err := ps.Blacklist.Set(key, match, scope, ttl); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Warningf("admin black key %s %s %s for %ds", key, match, scope, ttl)
+		writeJSON(w, http.StatusOK, map[string]string{"key": key})
+	case "DELETE":
+		key := r.FormValue("key")
+		if !ps.Blacklist.Remove(key) {
+			writeError(w, http.StatusNotFound, errors.New("remove key not exists"))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"key": key})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+	}
+}
+
+type adminSlots struct {
+	Start  int      `json:"start"`
+	End    int      `json:"end"`
+	Master string   `json:"master"`
+	Slaves []string `json:"slaves"`
+}
+
+func (ps *ProxyServer) adminTopology(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+	topology := map[string]interface{}{
+		"backend": ps.Conf.BackendType,
+		"nodes":   ps.Backend.Nodes(),
+	}
+	// only cluster has slots
+	if b, ok := ps.Backend.(*clusterBackend); ok {
+		slots := make([]adminSlots, 0)
+		for _, info := range b.SlotRanges() {
+			slots = append(slots, adminSlots{
+				Start:  info.Start,
+				End:    info.End,
+				Master: info.Addrs[0],
+				Slaves: info.Addrs[1:],
+			})
+		}
+		topology["slots"] = slots
+	}
+	writeJSON(w, http.StatusOK, topology)
+}
+
+func (ps *ProxyServer) adminPools(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, ps.Backend.PoolStats())
+}
+
+func (ps *ProxyServer) adminMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := ps.WriteMetrics(w); err != nil {
+		log.Warning("admin write metrics failed ", err)
+	}
+}
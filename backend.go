@@ -0,0 +1,355 @@
+package smartproxy
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/dongzerun/smartproxy/redis"
+)
+
+// Backend is the redis deployment proxy forwards requests to
+type Backend interface {
+	// Dispatch sends req to the node owns its key
+	Dispatch(req *redis.Request) redis.Cmder
+	// Pipeline sends reqs in one pipeline per shard, replies are in
+	// the order of reqs, error is the first failed one
+	Pipeline(reqs []*redis.Request) ([]redis.Cmder, error)
+	// Nodes returns addrs of nodes in use
+	Nodes() []string
+	// Broadcast sends req to every master, replies are keyed by addr
+	Broadcast(req *redis.Request) map[string]redis.Cmder
+	// Watch registers fn to be called after nodes changed
+	Watch(fn func())
+	// SetSlaveOk enables or disables reading from slaves
+	SetSlaveOk(ok bool)
+	// Info returns lines of PROXY INFO about backend
+	Info() []string
+	// PoolStats returns connections by node addr
+	PoolStats() map[string]redis.PoolStats
+	Close() error
+}
+
+// tunableBackend changes retries and timeouts of pc at runtime, for
+// commands and connections from now on
+type tunableBackend interface {
+	setOptions(pc *ProxyConfig)
+}
+
+// NewBackend creates backend of the configured type
+func NewBackend(c *ProxyConfig) (Backend, error) {
+	switch c.BackendType {
+	case BackendCluster:
+		return newClusterBackend(c.ClusterOptions()), nil
+	case BackendSingle:
+		return newSingleBackend(c), nil
+	case BackendSentinel:
+		return newSentinelBackend(c), nil
+	case BackendRing:
+		return newRingBackend(c)
+	}
+	return nil, fmt.Errorf("unknown backend type %s", c.BackendType)
+}
+
+// commandable is what every redis client and pipeline has,
+// On<NAME> methods are found by reflect
+type commandable interface {
+	OnReflectUnvalid(req *redis.Request) *redis.StringCmd
+	OnUnDenfined(req *redis.Request) *redis.StringCmd
+}
+
+type methodKey struct {
+	typ  reflect.Type
+	name string
+}
+
+var (
+	methodsLock sync.RWMutex
+	methods     = make(map[methodKey]reflect.Value, 120)
+)
+
+// dispatch calls c.On<NAME>(req), methods are cached by type
+func dispatch(c commandable, req *redis.Request) redis.Cmder {
+	name := req.Name()
+	key := methodKey{reflect.TypeOf(c), name}
+
+	methodsLock.RLock()
+	method, ok := methods[key]
+	methodsLock.RUnlock()
+
+	if !ok {
+		if m, found := key.typ.MethodByName("On" + name); found {
+			method = m.Func
+		}
+		methodsLock.Lock()
+		methods[key] = method
+		methodsLock.Unlock()
+	}
+
+	if !method.IsValid() {
+		return c.OnReflectUnvalid(req)
+	}
+	in := []reflect.Value{reflect.ValueOf(c), reflect.ValueOf(req)}
+	callResult := method.Call(in)
+	if callResult[0].Interface() != nil {
+		return callResult[0].Interface().(redis.Cmder)
+	}
+	return c.OnUnDenfined(req)
+}
+
+// redisPipeline is ClusterPipeline, RingPipeline or Pipeline
+type redisPipeline interface {
+	commandable
+	Exec() ([]redis.Cmder, error)
+	Close() error
+}
+
+func execPipeline(pipe redisPipeline, reqs []*redis.Request) ([]redis.Cmder, error) {
+	defer pipe.Close()
+	cmds := make([]redis.Cmder, len(reqs))
+	for i, req := range reqs {
+		cmds[i] = dispatch(pipe, req)
+	}
+	// Exec may return ASKING we added, use our own list
+	_, err := pipe.Exec()
+	return cmds, err
+}
+
+// broadcast sends req to each client in parallel
+func broadcast(req *redis.Request, forEach func(fn func(addr string, client *redis.Client))) map[string]redis.Cmder {
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+	)
+	result := make(map[string]redis.Cmder)
+	forEach(func(addr string, client *redis.Client) {
+		wg.Add(1)
+		go func() {
+			resp := dispatch(client, req)
+			lock.Lock()
+			result[addr] = resp
+			lock.Unlock()
+			wg.Done()
+		}()
+	})
+	wg.Wait()
+	return result
+}
+
+// watchers calls registered funcs after backend nodes changed
+type watchers struct {
+	lock sync.Mutex
+	fns  []func()
+}
+
+func (w *watchers) Watch(fn func()) {
+	w.lock.Lock()
+	w.fns = append(w.fns, fn)
+	w.lock.Unlock()
+}
+
+func (w *watchers) notify() {
+	w.lock.Lock()
+	fns := w.fns
+	w.lock.Unlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// clusterBackend is redis cluster
+type clusterBackend struct {
+	*redis.ClusterClient
+	watchers
+}
+
+func newClusterBackend(opt *redis.ClusterOptions) *clusterBackend {
+	b := &clusterBackend{ClusterClient: redis.NewClusterClient(opt)}
+	b.OnChange(b.notify)
+	return b
+}
+
+func (b *clusterBackend) Dispatch(req *redis.Request) redis.Cmder {
+	return dispatch(b.ClusterClient, req)
+}
+
+func (b *clusterBackend) Pipeline(reqs []*redis.Request) ([]redis.Cmder, error) {
+	return execPipeline(b.ClusterClient.Pipeline(), reqs)
+}
+
+func (b *clusterBackend) Nodes() []string {
+	return append([]string(nil), b.GetAddrs()...)
+}
+
+func (b *clusterBackend) Broadcast(req *redis.Request) map[string]redis.Cmder {
+	return broadcast(req, b.ForEachMaster)
+}
+
+func (b *clusterBackend) setOptions(pc *ProxyConfig) {
+	b.SetOptions(pc.ClusterOptions())
+}
+
+func (b *clusterBackend) Info() []string {
+	r := []string{"nodes:"}
+	r = append(r, b.Nodes()...)
+	r = append(r, "breakers:")
+	for addr, state := range b.BreakerStats() {
+		r = append(r, fmt.Sprintf("%s:%s", addr, redis.BreakerStateName(state)))
+	}
+	return r
+}
+
+//------------------------------------------------------------------------------
+
+// singleBackend is a standalone redis
+type singleBackend struct {
+	*redis.Client
+	watchers
+
+	addr string
+}
+
+func newSingleBackend(c *ProxyConfig) *singleBackend {
+	return &singleBackend{Client: redis.NewClient(c.SingleOptions()), addr: c.Addr}
+}
+
+func (b *singleBackend) Dispatch(req *redis.Request) redis.Cmder {
+	return dispatch(b.Client, req)
+}
+
+func (b *singleBackend) Pipeline(reqs []*redis.Request) ([]redis.Cmder, error) {
+	return execPipeline(b.Client.Pipeline(), reqs)
+}
+
+func (b *singleBackend) Nodes() []string {
+	return []string{b.addr}
+}
+
+func (b *singleBackend) Broadcast(req *redis.Request) map[string]redis.Cmder {
+	return map[string]redis.Cmder{b.addr: dispatch(b.Client, req)}
+}
+
+// SetSlaveOk does nothing, there is no slave
+func (b *singleBackend) SetSlaveOk(ok bool) {}
+
+func (b *singleBackend) PoolStats() map[string]redis.PoolStats {
+	return map[string]redis.PoolStats{b.addr: b.Client.PoolStats()}
+}
+
+func (b *singleBackend) setOptions(pc *ProxyConfig) {
+	b.SetOptions(pc.SingleOptions())
+}
+
+func (b *singleBackend) Info() []string {
+	return []string{fmt.Sprintf("addr:%s", b.addr)}
+}
+
+//------------------------------------------------------------------------------
+
+// sentinelBackend is a master/slaves group managed by sentinels
+type sentinelBackend struct {
+	*redis.FailoverClient
+	watchers
+
+	conf *ProxyConfig
+}
+
+func newSentinelBackend(c *ProxyConfig) *sentinelBackend {
+	b := &sentinelBackend{FailoverClient: redis.NewFailoverClient(c.FailoverOptions()), conf: c}
+	b.OnChange(b.notify)
+	return b
+}
+
+func (b *sentinelBackend) Dispatch(req *redis.Request) redis.Cmder {
+	return dispatch(b.FailoverClient, req)
+}
+
+func (b *sentinelBackend) Pipeline(reqs []*redis.Request) ([]redis.Cmder, error) {
+	return execPipeline(b.FailoverClient.Pipeline(), reqs)
+}
+
+func (b *sentinelBackend) Nodes() []string {
+	return append([]string{b.MasterAddr()}, b.SlaveAddrs()...)
+}
+
+func (b *sentinelBackend) Broadcast(req *redis.Request) map[string]redis.Cmder {
+	return map[string]redis.Cmder{b.MasterAddr(): dispatch(b.FailoverClient.Client, req)}
+}
+
+func (b *sentinelBackend) setOptions(pc *ProxyConfig) {
+	b.SetOptions(pc.FailoverOptions())
+}
+
+func (b *sentinelBackend) Info() []string {
+	r := []string{
+		fmt.Sprintf("mastername:%s", b.conf.MasterName),
+		fmt.Sprintf("master:%s", b.MasterAddr()),
+		"slaves:",
+	}
+	r = append(r, b.SlaveAddrs()...)
+	r = append(r, "sentinels:")
+	r = append(r, b.conf.Sentinels...)
+	return r
+}
+
+//------------------------------------------------------------------------------
+
+// ringBackend is twemproxy like sharding over standalone redis
+type ringBackend struct {
+	*redis.Ring
+	watchers
+
+	conf *ProxyConfig
+}
+
+func newRingBackend(c *ProxyConfig) (*ringBackend, error) {
+	ring, err := redis.NewRing(c.RingOptions())
+	if err != nil {
+		return nil, err
+	}
+	b := &ringBackend{Ring: ring, conf: c}
+	b.OnChange(b.notify)
+	return b, nil
+}
+
+func (b *ringBackend) Dispatch(req *redis.Request) redis.Cmder {
+	return dispatch(b.Ring, req)
+}
+
+func (b *ringBackend) Pipeline(reqs []*redis.Request) ([]redis.Cmder, error) {
+	return execPipeline(b.Ring.Pipeline(), reqs)
+}
+
+func (b *ringBackend) Nodes() []string {
+	nodes := make([]string, 0, len(b.conf.Servers))
+	for _, s := range b.conf.Servers {
+		nodes = append(nodes, s.Addr)
+	}
+	return nodes
+}
+
+func (b *ringBackend) Broadcast(req *redis.Request) map[string]redis.Cmder {
+	return broadcast(req, b.ForEachShard)
+}
+
+// SetSlaveOk does nothing, ring shards have no slaves
+func (b *ringBackend) SetSlaveOk(ok bool) {}
+
+func (b *ringBackend) setOptions(pc *ProxyConfig) {
+	b.SetOptions(pc.RingOptions())
+}
+
+func (b *ringBackend) Info() []string {
+	r := []string{
+		fmt.Sprintf("distribution:%s", b.conf.Distribution),
+		fmt.Sprintf("hash:%s", b.conf.Hash),
+		fmt.Sprintf("hashtag:%s", b.conf.HashTag),
+		"shards:",
+	}
+	for name, state := range b.Shards() {
+		r = append(r, fmt.Sprintf("%s:%s", name, state))
+	}
+	return r
+}
@@ -0,0 +1,307 @@
+package smartproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dongzerun/smartproxy/redis"
+	log "github.com/ngaut/logging"
+)
+
+const (
+	BlackExact  = "exact"
+	BlackPrefix = "prefix"
+	BlackGlob   = "glob"
+
+	BlackScopeAll   = "all"
+	BlackScopeWrite = "write"
+	BlackScopeRead  = "read"
+
+	// seconds a key can be blacked at most
+	MaxBlackTime = 86400
+)
+
+var KeyBlacked = errors.New("key already be blacked")
+
+// BlackEntry rejects keys matching Pattern in commands of Scope
+type BlackEntry struct {
+	Pattern  string    `json:"pattern"`
+	Match    string    `json:"match"` // exact, prefix or glob
+	Scope    string    `json:"scope"` // all, write or read
+	Startup  time.Time `json:"startup"`
+	Deadline time.Time `json:"deadline"` // zero never expires
+	Config   bool      `json:"-"`        // from blacklist::keys, not saved
+}
+
+// TTL returns remaining time, -1 if entry never expires
+func (e *BlackEntry) TTL(now time.Time) time.Duration {
+	if e.Deadline.IsZero() {
+		return -1
+	}
+	return e.Deadline.Sub(now)
+}
+
+func (e *BlackEntry) expired(now time.Time) bool {
+	return !e.Deadline.IsZero() && !now.Before(e.Deadline)
+}
+
+func (e *BlackEntry) matches(key string) bool {
+	switch e.Match {
+	case BlackPrefix:
+		return strings.HasPrefix(key, e.Pattern)
+	case BlackGlob:
+		ok, _ := path.Match(e.Pattern, key)
+		return ok
+	}
+	return key == e.Pattern
+}
+
+func (e *BlackEntry) covers(cmd string) bool {
+	switch e.Scope {
+	case BlackScopeWrite:
+		return redis.IsWrite(cmd)
+	case BlackScopeRead:
+		return redis.IsReadOnly(cmd)
+	}
+	return true
+}
+
+// Blacklist rejects commands on keys we shed during incidents. Exact
+// keys are looked up in a map, prefixes and globs are scanned. Expired
+// entries are ignored and dropped on next change. Entries are saved to
+// file, if set, so they survive restarts.
+type Blacklist struct {
+	file string
+
+	lock     sync.RWMutex
+	exact    map[string]*BlackEntry // by pattern
+	patterns []*BlackEntry          // prefix and glob
+}
+
+// NewBlacklist loads entries saved in file, empty file disables saving
+func NewBlacklist(file string) (*Blacklist, error) {
+	b := &Blacklist{file: file, exact: make(map[string]*BlackEntry)}
+	if file == "" {
+		return b, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*BlackEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("bad blacklist file %s, %s", file, err)
+	}
+	now := time.Now()
+	for _, e := range entries {
+		if !e.expired(now) {
+			b.add(e)
+		}
+	}
+	log.Infof("load %d black entries from %s", len(entries), file)
+	return b, nil
+}
+
+// Set blacks keys matching pattern in commands of scope for seconds
+func (b *Blacklist) Set(pattern, match, scope string, seconds int) error {
+	if seconds > MaxBlackTime || seconds < 0 {
+		return fmt.Errorf("black time must between 0 ~ %d", MaxBlackTime)
+	}
+	switch match {
+	case BlackExact, BlackPrefix:
+	case BlackGlob:
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad glob %s", pattern)
+		}
+	default:
+		return errors.New("match must be exact prefix or glob")
+	}
+	switch scope {
+	case BlackScopeAll, BlackScopeWrite, BlackScopeRead:
+	default:
+		return errors.New("scope must be all write or read")
+	}
+
+	now := time.Now()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.add(&BlackEntry{
+		Pattern:  pattern,
+		Match:    match,
+		Scope:    scope,
+		Startup:  now,
+		Deadline: now.Add(time.Duration(seconds) * time.Second),
+	})
+	b.save(now)
+	return nil
+}
+
+// add replaces entry of the same pattern and match, b.lock must be held
+func (b *Blacklist) add(e *BlackEntry) {
+	if e.Match == BlackExact {
+		b.exact[e.Pattern] = e
+		return
+	}
+	for i, p := range b.patterns {
+		if p.Pattern == e.Pattern && p.Match == e.Match {
+			b.patterns[i] = e
+			return
+		}
+	}
+	b.patterns = append(b.patterns, e)
+}
+
+// Remove reports whether pattern was blacked, entries of any match are
+// removed
+func (b *Blacklist) Remove(pattern string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	_, found := b.exact[pattern]
+	delete(b.exact, pattern)
+	patterns := b.patterns[:0]
+	for _, e := range b.patterns {
+		if e.Pattern == pattern {
+			found = true
+			continue
+		}
+		patterns = append(patterns, e)
+	}
+	b.patterns = patterns
+	if found {
+		log.Warning("remove black entry ", pattern)
+		b.save(time.Now())
+	}
+	return found
+}
+
+// SetConfig replaces entries of blacklist::keys, they never expire
+func (b *Blacklist) SetConfig(keys []string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for pattern, e := range b.exact {
+		if e.Config {
+			delete(b.exact, pattern)
+		}
+	}
+	now := time.Now()
+	for _, key := range keys {
+		b.add(&BlackEntry{Pattern: key, Match: BlackExact, Scope: BlackScopeAll, Startup: now, Config: true})
+	}
+}
+
+// List returns copies of entries not expired by pattern
+func (b *Blacklist) List() []BlackEntry {
+	now := time.Now()
+	b.lock.RLock()
+	entries := make([]BlackEntry, 0, len(b.exact)+len(b.patterns))
+	for _, e := range b.exact {
+		if !e.expired(now) {
+			entries = append(entries, *e)
+		}
+	}
+	for _, e := range b.patterns {
+		if !e.expired(now) {
+			entries = append(entries, *e)
+		}
+	}
+	b.lock.RUnlock()
+	sort.Sort(byPattern(entries))
+	return entries
+}
+
+// Blocked reports whether any of keys is blacked for cmd
+func (b *Blacklist) Blocked(cmd string, keys []string) bool {
+	if len(keys) == 0 {
+		return false
+	}
+	now := time.Now()
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for _, key := range keys {
+		if e, ok := b.exact[key]; ok && !e.expired(now) && e.covers(cmd) {
+			return true
+		}
+		for _, e := range b.patterns {
+			if !e.expired(now) && e.covers(cmd) && e.matches(key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// save drops expired entries and writes others but config ones to file,
+// b.lock must be held
+func (b *Blacklist) save(now time.Time) {
+	entries := make([]*BlackEntry, 0, len(b.exact)+len(b.patterns))
+	for pattern, e := range b.exact {
+		if e.expired(now) {
+			delete(b.exact, pattern)
+		} else if !e.Config {
+			entries = append(entries, e)
+		}
+	}
+	patterns := b.patterns[:0]
+	for _, e := range b.patterns {
+		if !e.expired(now) {
+			patterns = append(patterns, e)
+			entries = append(entries, e)
+		}
+	}
+	b.patterns = patterns
+
+	if b.file == "" {
+		return
+	}
+	data, err := json.Marshal(entries)
+	if err == nil {
+		tmp := b.file + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, b.file)
+		}
+	}
+	if err != nil {
+		log.Warning("save blacklist failed ", err)
+	}
+}
+
+// byPattern sorts BlackEntry by pattern
+type byPattern []BlackEntry
+
+func (s byPattern) Len() int           { return len(s) }
+func (s byPattern) Less(i, j int) bool { return s[i].Pattern < s[j].Pattern }
+func (s byPattern) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// requestKeys returns key args of req, multi key commands of proxy may
+// have non key args which are checked too
+func requestKeys(req *redis.Request) []string {
+	args := req.Args()
+	if len(args) == 0 {
+		return nil
+	}
+	switch req.Name() {
+	case "PROXY", "CLUSTER":
+		return nil
+	case "MSET", "MSETNX":
+		keys := make([]string, 0, (len(args)+1)/2)
+		for i := 0; i < len(args); i += 2 {
+			keys = append(keys, args[i])
+		}
+		return keys
+	}
+	if isSpecCommand(req.Name()) {
+		return args
+	}
+	return args[:1]
+}
@@ -0,0 +1,252 @@
+package smartproxy
+
+import (
+	"bytes"
+	"container/list"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dongzerun/smartproxy/redis"
+)
+
+// reads can be answered by cache
+var cacheCommands = map[string]bool{
+	"GET":      true,
+	"HGET":     true,
+	"HGETALL":  true,
+	"SMEMBERS": true,
+}
+
+var nilReply = []byte("$-1")
+
+const (
+	// bytes counted for each entry besides key and reply
+	cacheEntryOverhead = 64
+	// keys tracked for hot detection at most
+	cacheMaxTracked = 100000
+)
+
+type cacheEntry struct {
+	id     string // command and args
+	key    string // redis key
+	reply  []byte
+	expire time.Time
+}
+
+func (e *cacheEntry) size() int64 {
+	return int64(len(e.id) + len(e.key) + len(e.reply) + cacheEntryOverhead)
+}
+
+// Cache is a LRU cache of read replies of hot keys. Keys matching
+// patterns or read more than hotQps times a second are cached for ttl.
+// Writes through proxy invalidate the key, a reply may still be stale
+// for ttl if it raced with a write.
+type Cache struct {
+	ttl      time.Duration
+	maxBytes int64
+	patterns []string
+	hotQps   int
+
+	lock    sync.Mutex
+	lru     *list.List               // front is most recently used
+	entries map[string]*list.Element // by id
+	byKey   map[string]map[string]struct{}
+	bytes   int64
+
+	// reads per key in current second, hot keys of last second
+	window time.Time
+	counts map[string]int
+	hot    map[string]struct{}
+
+	// atomic
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+func NewCache(ttl time.Duration, maxBytes int64, patterns []string, hotQps int) *Cache {
+	return &Cache{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		patterns: patterns,
+		hotQps:   hotQps,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		byKey:    make(map[string]map[string]struct{}),
+		window:   time.Now(),
+		counts:   make(map[string]int),
+		hot:      make(map[string]struct{}),
+	}
+}
+
+func requestId(req *redis.Request) string {
+	return req.Name() + " " + strings.Join(req.Args(), " ")
+}
+
+// Get returns cached reply of req
+func (c *Cache) Get(req *redis.Request) ([]byte, bool) {
+	if !cacheCommands[req.Name()] || len(req.Args()) == 0 {
+		return nil, false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.track(req.Args()[0])
+	if el, ok := c.entries[requestId(req)]; ok {
+		e := el.Value.(*cacheEntry)
+		if time.Now().Before(e.expire) {
+			c.lru.MoveToFront(el)
+			atomic.AddInt64(&c.hits, 1)
+			return e.reply, true
+		}
+		c.remove(el)
+	}
+	if c.cacheable(req.Args()[0]) {
+		atomic.AddInt64(&c.misses, 1)
+	}
+	return nil, false
+}
+
+// Update caches reply of read req, or invalidates key written by req
+func (c *Cache) Update(req *redis.Request) {
+	args := req.Args()
+	if len(args) == 0 {
+		return
+	}
+	name := req.Name()
+	if redis.IsWrite(name) {
+		c.Invalidate(args[0])
+		return
+	}
+	if !cacheCommands[name] {
+		return
+	}
+
+	reply := req.Result()
+	// errors and nil are not cached
+	if len(reply) == 0 || reply[0] == '-' || bytes.HasPrefix(reply, nilReply) {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.cacheable(args[0]) {
+		return
+	}
+	e := &cacheEntry{
+		id:     requestId(req),
+		key:    args[0],
+		reply:  reply,
+		expire: time.Now().Add(c.ttl),
+	}
+	if e.size() > c.maxBytes {
+		return
+	}
+	if el, ok := c.entries[e.id]; ok {
+		c.remove(el)
+	}
+	c.entries[e.id] = c.lru.PushFront(e)
+	ids, ok := c.byKey[e.key]
+	if !ok {
+		ids = make(map[string]struct{})
+		c.byKey[e.key] = ids
+	}
+	ids[e.id] = struct{}{}
+	c.bytes += e.size()
+
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+		atomic.AddInt64(&c.evictions, 1)
+	}
+}
+
+// Invalidate drops all cached replies of key
+func (c *Cache) Invalidate(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for id := range c.byKey[key] {
+		if el, ok := c.entries[id]; ok {
+			c.remove(el)
+		}
+	}
+}
+
+// Clear drops everything, like after topology changed
+func (c *Cache) Clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.byKey = make(map[string]map[string]struct{})
+	c.bytes = 0
+}
+
+// Stats returns hits, misses, evictions and entries
+func (c *Cache) Stats() map[string]int64 {
+	c.lock.Lock()
+	entries := int64(len(c.entries))
+	bytes := c.bytes
+	c.lock.Unlock()
+	return map[string]int64{
+		"hits":      atomic.LoadInt64(&c.hits),
+		"misses":    atomic.LoadInt64(&c.misses),
+		"evictions": atomic.LoadInt64(&c.evictions),
+		"entries":   entries,
+		"bytes":     bytes,
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.id)
+	if ids, ok := c.byKey[e.key]; ok {
+		delete(ids, e.id)
+		if len(ids) == 0 {
+			delete(c.byKey, e.key)
+		}
+	}
+	c.bytes -= e.size()
+}
+
+// cacheable reports whether key matches patterns or is hot
+func (c *Cache) cacheable(key string) bool {
+	for _, pattern := range c.patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	_, ok := c.hot[key]
+	return ok
+}
+
+// track counts reads of key, keys read hotQps times in a second are
+// hot in this and next second
+func (c *Cache) track(key string) {
+	if c.hotQps <= 0 {
+		return
+	}
+	if now := time.Now(); now.Sub(c.window) > time.Second {
+		c.hot = make(map[string]struct{})
+		for k, n := range c.counts {
+			if n >= c.hotQps {
+				c.hot[k] = struct{}{}
+			}
+		}
+		c.counts = make(map[string]int)
+		c.window = now
+	}
+
+	n, ok := c.counts[key]
+	if !ok && len(c.counts) >= cacheMaxTracked {
+		return
+	}
+	c.counts[key] = n + 1
+	if n+1 >= c.hotQps {
+		c.hot[key] = struct{}{}
+	}
+}
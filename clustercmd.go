@@ -0,0 +1,117 @@
+package smartproxy
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dongzerun/smartproxy/redis"
+	"github.com/dongzerun/smartproxy/util"
+	log "github.com/ngaut/logging"
+)
+
+// CLUSTER makes the proxy look like a single node cluster, which owns
+// all 16384 slots, so cluster-mode clients can talk to us unchanged.
+func (s *Session) CLUSTER(req *redis.Request) {
+	op := strings.ToLower(req.Args()[0])
+	switch op {
+	case "slots":
+		if len(req.Args()) != 1 {
+			err := fmt.Sprintf("-%s\r\n", WrongArgumentCount)
+			s.write2client([]byte(err))
+			return
+		}
+		s.clusterSlots(req)
+	case "nodes":
+		if len(req.Args()) != 1 {
+			err := fmt.Sprintf("-%s\r\n", WrongArgumentCount)
+			s.write2client([]byte(err))
+			return
+		}
+		s.clusterNodes(req)
+	case "info":
+		if len(req.Args()) != 1 {
+			err := fmt.Sprintf("-%s\r\n", WrongArgumentCount)
+			s.write2client([]byte(err))
+			return
+		}
+		s.clusterInfo(req)
+	case "keyslot":
+		if len(req.Args()) != 2 {
+			err := fmt.Sprintf("-%s\r\n", WrongArgumentCount)
+			s.write2client([]byte(err))
+			return
+		}
+		slot := redis.KeySlot(req.Args()[1])
+		s.write2client(redis.FormatInt(int64(slot)))
+	default:
+		log.Warning("Unknow cluster op type: ", req.Args())
+		err := fmt.Sprintf("-%s\r\n", CommandNotSupported)
+		s.write2client([]byte(err))
+	}
+}
+
+// clusterAddr returns the address client used to connect to us,
+// proxy listens on 0.0.0.0 so we can't use the listen address.
+func (s *Session) clusterAddr() (string, int) {
+	host, port, err := net.SplitHostPort(s.Conn.LocalAddr().String())
+	if err != nil {
+		host = "127.0.0.1"
+		port = s.Proxy.Conf.Port
+	}
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+// clusterNodeId fakes a 40 bytes node id, which is stable for the addr
+func clusterNodeId(host string, port int) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(net.JoinHostPort(host, util.Itoa(port)))))
+}
+
+// *1
+// *3 :0 :16383 *2 $ip :port
+func (s *Session) clusterSlots(req *redis.Request) {
+	host, port := s.clusterAddr()
+
+	b := bytes.Buffer{}
+	b.WriteString("*1\r\n*3\r\n")
+	b.Write(redis.FormatInt(0))
+	b.Write(redis.FormatInt(MaxSlot))
+	b.WriteString("*2\r\n")
+	b.Write(redis.FormatString(host))
+	b.Write(redis.FormatInt(int64(port)))
+	s.write2client(b.Bytes())
+}
+
+func (s *Session) clusterNodes(req *redis.Request) {
+	host, port := s.clusterAddr()
+	addr := net.JoinHostPort(host, util.Itoa(port))
+	id := clusterNodeId(host, port)
+
+	// <id> <ip:port> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot>
+	node := fmt.Sprintf("%s %s myself,master - 0 %d 0 connected 0-%d\n",
+		id, addr, time.Now().UnixNano()/1e6, MaxSlot)
+	s.write2client(redis.FormatString(node))
+}
+
+func (s *Session) clusterInfo(req *redis.Request) {
+	r := []string{
+		"cluster_state:ok",
+		fmt.Sprintf("cluster_slots_assigned:%d", MaxSlot+1),
+		fmt.Sprintf("cluster_slots_ok:%d", MaxSlot+1),
+		"cluster_slots_pfail:0",
+		"cluster_slots_fail:0",
+		"cluster_known_nodes:1",
+		"cluster_size:1",
+		"cluster_current_epoch:0",
+		"cluster_my_epoch:0",
+		"cluster_stats_messages_sent:0",
+		"cluster_stats_messages_received:0",
+	}
+	info := strings.Join(r, "\r\n") + "\r\n"
+	s.write2client(redis.FormatString(info))
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"flag"
+	"os"
+	"syscall"
 
 	proxy "github.com/dongzerun/smartproxy"
 	"github.com/dongzerun/smartproxy/util"
@@ -23,9 +25,34 @@ func main() {
 	s.Wg.Wrap(s.Run)
 	s.Wg.Wrap(s.QpsStats)
 	s.Wg.Wrap(s.QpsSend)
+	s.Wg.Wrap(s.StatsdBackendStats)
+	s.Wg.Wrap(s.StatsdMigrateStats)
+	s.Wg.Wrap(s.StatsdMirrorStats)
+	s.Wg.Wrap(s.StatsdCacheStats)
+	s.Wg.Wrap(s.StatsdKeyStats)
+	s.Wg.Wrap(s.StatsdCoalesceStats)
+	s.Wg.Wrap(s.StatsdEvents)
+	s.Wg.Wrap(s.StatsdMemStats)
 	s.Wg.Wrap(s.SaveConfigToFile)
-
-	util.RegisterSignalAndWait()
+	s.Wg.Wrap(s.ServeAdmin)
+	s.Wg.Wrap(s.ServeRegistry)
+
+	sig := util.RegisterSignalAndWait(map[os.Signal]func(){
+		syscall.SIGHUP: func() {
+			// errors and ignored options are logged
+			s.ReloadConfig()
+		},
+		syscall.SIGUSR2: func() {
+			// new process serves, this one drains as on SIGTERM
+			if _, err := s.Upgrade(); err == nil {
+				syscall.Kill(os.Getpid(), syscall.SIGTERM)
+			}
+		},
+	})
+	// SIGTERM from orchestrators lets sessions finish, SIGINT quits now
+	if sig == syscall.SIGTERM {
+		s.Drain()
+	}
 
 	s.Close()
 	log.Warning("quit redis proxy")
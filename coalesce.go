@@ -0,0 +1,96 @@
+package smartproxy
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/dongzerun/smartproxy/redis"
+)
+
+// flight is a backend call shared by identical requests
+type flight struct {
+	wg      sync.WaitGroup
+	resp    redis.Cmder
+	waiters int
+}
+
+// Coalescer lets identical concurrent reads share one backend call,
+// like cache stampede after a hot key expired. Every waiter formats its
+// own reply from the shared resp.
+type Coalescer struct {
+	commands   map[string]bool
+	maxWaiters int
+
+	lock    sync.Mutex
+	flights map[string]*flight // by command and args
+
+	// atomic
+	calls     int64 // backend calls of coalescable commands
+	coalesced int64 // requests answered by others call
+	overflows int64 // flights full, requests called backend themselves
+}
+
+func NewCoalescer(commands []string, maxWaiters int) *Coalescer {
+	c := &Coalescer{
+		commands:   make(map[string]bool),
+		maxWaiters: maxWaiters,
+		flights:    make(map[string]*flight),
+	}
+	for _, name := range commands {
+		// writes are never shared
+		if redis.IsReadOnly(name) {
+			c.commands[name] = true
+		}
+	}
+	return c
+}
+
+// Do calls fn with req, or waits for the same request in flight
+func (c *Coalescer) Do(req *redis.Request, fn func(*redis.Request) redis.Cmder) redis.Cmder {
+	if !c.commands[req.Name()] {
+		return fn(req)
+	}
+	id := requestId(req)
+
+	c.lock.Lock()
+	if f, ok := c.flights[id]; ok {
+		if f.waiters >= c.maxWaiters {
+			c.lock.Unlock()
+			atomic.AddInt64(&c.overflows, 1)
+			return fn(req)
+		}
+		f.waiters++
+		c.lock.Unlock()
+
+		f.wg.Wait()
+		// fn panicked
+		if f.resp == nil {
+			return fn(req)
+		}
+		atomic.AddInt64(&c.coalesced, 1)
+		return f.resp
+	}
+	f := &flight{}
+	f.wg.Add(1)
+	c.flights[id] = f
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.flights, id)
+		c.lock.Unlock()
+		f.wg.Done()
+	}()
+	atomic.AddInt64(&c.calls, 1)
+	f.resp = fn(req)
+	return f.resp
+}
+
+// Stats returns calls, coalesced and overflows
+func (c *Coalescer) Stats() map[string]int64 {
+	return map[string]int64{
+		"calls":     atomic.LoadInt64(&c.calls),
+		"coalesced": atomic.LoadInt64(&c.coalesced),
+		"overflows": atomic.LoadInt64(&c.overflows),
+	}
+}
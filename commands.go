@@ -0,0 +1,136 @@
+package smartproxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dongzerun/smartproxy/redis"
+)
+
+// Commands is the command table of [commands] config on top of builtin
+// blackList and reqrules. It is never modified, reload replaces it.
+type Commands struct {
+	deny   map[string]bool
+	allow  map[string]bool
+	rename map[string]string // command -> name called by clients, "" disabled
+	alias  map[string]string // name called by clients -> command
+}
+
+var noCommands = &Commands{}
+
+// NewCommands checks names are not conflicting, rename maps command to
+// new name like rename-command of redis, empty name disables command
+func NewCommands(deny, allow []string, rename map[string]string) (*Commands, error) {
+	c := &Commands{
+		deny:   make(map[string]bool),
+		allow:  make(map[string]bool),
+		rename: make(map[string]string),
+		alias:  make(map[string]string),
+	}
+	for _, name := range deny {
+		name = strings.ToUpper(name)
+		if name == "PROXY" {
+			return nil, fmt.Errorf("command %s can not be denied", name)
+		}
+		c.deny[name] = true
+	}
+	for _, name := range allow {
+		name = strings.ToUpper(name)
+		if c.deny[name] {
+			return nil, fmt.Errorf("command %s both denied and allowed", name)
+		}
+		if !isServedCommand(name) {
+			return nil, fmt.Errorf("command %s can not be allowed, not supported by proxy", name)
+		}
+		c.allow[name] = true
+	}
+	for cmd, name := range rename {
+		cmd, name = strings.ToUpper(cmd), strings.ToUpper(name)
+		if name != "" {
+			if !isServedCommand(cmd) {
+				return nil, fmt.Errorf("command %s can not be renamed, not supported by proxy", cmd)
+			}
+			if isKnownCommand(name) {
+				return nil, fmt.Errorf("rename %s to existing command %s", cmd, name)
+			}
+			if other, ok := c.alias[name]; ok {
+				return nil, fmt.Errorf("rename %s and %s to same name %s", cmd, other, name)
+			}
+			c.alias[name] = cmd
+		}
+		c.rename[cmd] = name
+	}
+	return c, nil
+}
+
+// isKnownCommand reports whether name is a command of proxy, allowed or
+// not
+func isKnownCommand(name string) bool {
+	_, rule := reqrules[name]
+	return rule || blackList[name] || specList[name]
+}
+
+// isServedCommand reports whether name is really run by proxy, commands
+// without reqrules fail in dispatch and stubs reply +OK doing nothing
+func isServedCommand(name string) bool {
+	_, rule := reqrules[name]
+	return rule && !stubList[name]
+}
+
+// resolve renames req called by new name to its command, renamed is
+// true if so. Commands renamed or denied are rejected.
+func (c *Commands) resolve(req *redis.Request) (renamed bool, err error) {
+	name := req.Name()
+	if cmd, ok := c.alias[name]; ok {
+		req.SetName(cmd)
+		name, renamed = cmd, true
+	} else if _, ok := c.rename[name]; ok {
+		// hidden like unknown commands
+		return false, BadCommandError
+	}
+	if c.deny[name] {
+		return renamed, CommandForbidden
+	}
+	return renamed, nil
+}
+
+// allowed reports whether name is allowed even in builtin blackList
+func (c *Commands) allowed(name string) bool {
+	return c.allow[name]
+}
+
+// List returns one line for every entry, like deny HGETALL, allow SORT
+// and rename FLUSHALL name
+func (c *Commands) List() []string {
+	lines := make([]string, 0, len(c.deny)+len(c.allow)+len(c.rename))
+	for name := range c.deny {
+		lines = append(lines, "deny "+name)
+	}
+	for name := range c.allow {
+		lines = append(lines, "allow "+name)
+	}
+	for cmd, name := range c.rename {
+		lines = append(lines, strings.TrimSpace("rename "+cmd+" "+name))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// Commands returns command table in use
+func (ps *ProxyServer) Commands() *Commands {
+	if c, ok := ps.commands.Load().(*Commands); ok {
+		return c
+	}
+	return noCommands
+}
+
+// setCommands replaces command table by [commands] of pc
+func (ps *ProxyServer) setCommands(pc *ProxyConfig) error {
+	c, err := NewCommands(pc.DenyCommands, pc.AllowCommands, pc.RenameCommands)
+	if err != nil {
+		return err
+	}
+	ps.commands.Store(c)
+	return nil
+}
@@ -0,0 +1,58 @@
+package smartproxy
+
+import (
+	"testing"
+
+	"github.com/dongzerun/smartproxy/redis"
+)
+
+func TestNewCommandsRejectsUnserved(t *testing.T) {
+	tests := []struct {
+		allow  []string
+		rename map[string]string
+		ok     bool
+	}{
+		{allow: []string{"sort"}, ok: true},
+		{rename: map[string]string{"DEL": "del_7c1e", "FLUSHALL": ""}, ok: true},
+		// stubs of special.go reply +OK doing nothing
+		{allow: []string{"RENAME"}},
+		{allow: []string{"SMOVE"}},
+		{rename: map[string]string{"SINTER": "inter"}},
+		// no reqrules, fails in dispatch
+		{allow: []string{"KEYS"}},
+		{rename: map[string]string{"FLUSHALL": "flushall_7c1e"}},
+	}
+	for _, tt := range tests {
+		_, err := NewCommands(nil, tt.allow, tt.rename)
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("allow %v rename %v got %v, want ok %v", tt.allow, tt.rename, err, tt.ok)
+		}
+	}
+}
+
+func TestPreCheckAllowedCommand(t *testing.T) {
+	allowed, err := NewCommands(nil, []string{"SORT"}, map[string]string{"DEL": "del_7c1e"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		cmds *Commands
+		req  []string
+		err  error
+	}{
+		{noCommands, []string{"SORT", "list"}, CommandForbidden},
+		{allowed, []string{"SORT", "list", "ALPHA"}, nil},
+		// allowed commands are checked by reqrules too
+		{allowed, []string{"SORT"}, WrongArgumentCount},
+		{allowed, []string{"del_7c1e"}, WrongArgumentCount},
+		{allowed, []string{"del_7c1e", "a", "b"}, nil},
+		{allowed, []string{"DEL", "a"}, BadCommandError},
+		{allowed, []string{"KEYS", "*"}, CommandForbidden},
+	}
+	for _, tt := range tests {
+		_, _, _, err := preCheckCommand(redis.NewRequest(tt.req), tt.cmds)
+		if err != tt.err {
+			t.Errorf("%v got %v, want %v", tt.req, err, tt.err)
+		}
+	}
+}
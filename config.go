@@ -1,13 +1,18 @@
 package smartproxy
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/astaxie/beego/config"
+	"github.com/dongzerun/smartproxy/redis"
 	log "github.com/ngaut/logging"
 )
 
@@ -17,17 +22,105 @@ type ProxyConfig struct {
 	Port            string   // proxy listen port
 	Nodes           []string // redis node like 127.0.0.1:6379
 	SlaveOk         bool     // if we can read from slave
+	SlaveSelect     string   // random, roundrobin or latency
 	IdleTime        int64
+	GraceTime       int64 // seconds sessions are drained before closed
 	MaxConn         int64
 	MulOpParallel   int
 	PoolSizePerNode int
+	Cpus            int
+
+	LogLevel string
+	LogFile  string
+
+	BlackKeys []string // blacked until removed from config
+	BlackFile string   // keeps entries blacked by command across restarts
+
+	DenyCommands   []string          // forbidden besides builtin ones
+	AllowCommands  []string          // allowed though forbidden by builtin ones
+	RenameCommands map[string]string // command -> new name, "" disabled
+
+	BackendType string   // cluster, single, sentinel or ring
+	Addr        string   // single redis addr
+	MasterName  string   // sentinel master name
+	Sentinels   []string // sentinel addrs like 127.0.0.1:26379
+
+	// ring backend, same meaning as twemproxy pool config
+	Servers      []redis.RingShard
+	Distribution string // ketama or modula
+	Hash         string // fnv1a_64, md5, crc32 or murmur
+	HashTag      string // like {}
+	AutoEject    bool
+	FailureLimit int
+	RetryTimeout int64 // ms
+
+	// dual write migration, Secondary is nil if not configured
+	Secondary    *ProxyConfig
+	MigratePhase string // off, dualwrite or readsecondary
+	MigrateAsync bool   // write secondary in background
+
+	// copy sampled requests to shadow cluster, disabled if no nodes
+	MirrorNodes    []string
+	MirrorPercent  int  // 0 ~ 100
+	MirrorReadOnly bool // only copy read only commands
+	MirrorCompare  bool // compare replies of shadow
+	MirrorQueue    int
+
+	// hot key read cache
+	Cache         bool
+	CacheTTL      int64    // ms
+	CacheMemory   int64    // MB
+	CachePatterns []string // glob like KEYS, matched keys are cached
+	CacheHotQps   int      // keys read more often are cached, 0 disabled
+
+	// hot key and big key detection
+	KeyStats        bool
+	KeyStatsPercent int   // sampled requests, 1 ~ 100
+	KeyStatsTopK    int   // keys kept
+	HotKeyQps       int64 // warn threshold, 0 disabled
+	BigKeySize      int64 // warn threshold in bytes, 0 disabled
+
+	// identical concurrent reads share one backend call
+	Coalesce         bool
+	CoalesceCommands []string
+	CoalesceWaiters  int // waiters of one call at most
+
+	// backend options, timeouts in ms
+	DialTimeout  int64
+	ReadTimeout  int64
+	WriteTimeout int64
+	PoolTimeout  int64
+	IdleTimeout  int64    // close idle backend conns, 0 disabled
+	MaxRedirects int      // MOVED/ASK redirects to follow
+	MaxRetries   int      // retries on network error
+	MuxConns     int      // multiplexed conns per node, 0 use pool
+	SlowCommands []string // commands use SlowTimeout, like DUMP RESTORE
+	SlowTimeout  int64
+
+	RefreshInterval int64 // ms, reload cluster slots besides after MOVED, 0 disabled
+
+	RetryBudget int64 // ms, retry TRYAGAIN CLUSTERDOWN LOADING within it
+
+	Breaker         bool  // per node circuit breaker
+	BreakerRate     int   // percent of failed commands to open breaker
+	BreakerSlow     int64 // ms, slower commands count as failed, 0 disabled
+	BreakerCooldown int64 // ms, breaker stays open before probing
 
 	Statsd       string // statsd addr
 	StatsdPrefix string
+	StatsdTags   []string // DogStatsD tags like env:prod
+	StatsdSample float64  // sample rate of per request metrics, 0 ~ 1
 
 	Zk     string
 	ZkPath string
 
+	// publish proxy for clients, zk if Zk is set, or file
+	RegistryType string
+	RegistryPath string // dir of file registry
+	RegistryAddr string // ip published, local ip if empty
+
+	AdminPort string // http admin api, empty disabled
+
 	FileName string
 	Config   config.ConfigContainer
 }
@@ -51,36 +144,160 @@ func NewProxyConfig(filename string) *ProxyConfig {
 		log.SetRotateByDay()
 	}
 
-	cpus := c.DefaultInt("proxy::cpus", 4)
-	log.Info("set runtime GOMAXPROCS to ", cpus)
-	runtime.GOMAXPROCS(cpus)
+	pc, err := parseProxyConfig(c, filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Info("set runtime GOMAXPROCS to ", pc.Cpus)
+	runtime.GOMAXPROCS(pc.Cpus)
+
+	fcpu := c.DefaultString("debug::cpufile", "")
+	if fcpu != "" {
+		f, err := os.Create(fcpu)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pprof.StartCPUProfile(f)
+		defer pprof.StopCPUProfile()
+	}
+
+	fmem := c.DefaultString("debug::memfile", "")
+	if fmem != "" {
+		f, err := os.Create(fmem)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pprof.WriteHeapProfile(f)
+	}
+	return pc
+}
+
+// LoadProxyConfig reads and validates filename like NewProxyConfig, but
+// returns errors and changes nothing of the running proxy
+func LoadProxyConfig(filename string) (*ProxyConfig, error) {
+	c, err := config.NewConfig("ini", filename)
+	if err != nil {
+		return nil, fmt.Errorf("read config file failed %s", err)
+	}
+	return parseProxyConfig(c, filename)
+}
 
+func parseProxyConfig(c config.ConfigContainer, filename string) (*ProxyConfig, error) {
 	pc := &ProxyConfig{
 		Id:              c.DefaultString("product::id", ""),
 		Name:            c.DefaultString("product::name", ""),
 		Port:            c.DefaultString("proxy::port", ""),
 		SlaveOk:         c.DefaultBool("proxy::slaveok", false),
+		SlaveSelect:     c.DefaultString("proxy::slaveselect", redis.SlaveSelectRandom),
 		IdleTime:        c.DefaultInt64("proxy::idletime", 300),
+		GraceTime:       c.DefaultInt64("proxy::gracetime", 30),
 		MaxConn:         c.DefaultInt64("proxy::maxconn", 60000),
 		Statsd:          c.DefaultString("proxy::statsd", ""),
 		Zk:              c.DefaultString("zk::zk", ""),
 		ZkPath:          c.DefaultString("zk::zkpath", ""),
+		RegistryType:    c.DefaultString("registry::type", ""),
+		RegistryPath:    c.DefaultString("registry::path", ""),
+		RegistryAddr:    c.DefaultString("registry::addr", ""),
+		AdminPort:       c.DefaultString("admin::port", ""),
+		BlackFile:       c.DefaultString("blacklist::file", ""),
 		MulOpParallel:   c.DefaultInt("proxy::mulparallel", 10),
 		PoolSizePerNode: c.DefaultInt("proxy::poolsizepernode", 30),
+		Cpus:            c.DefaultInt("proxy::cpus", 4),
+		LogLevel:        strings.ToLower(c.DefaultString("log::loglevel", "info")),
+		LogFile:         c.DefaultString("log::logfile", ""),
 		StatsdPrefix:    c.DefaultString("proxy::prefix", "redis.proxy."),
+		StatsdSample:    c.DefaultFloat("proxy::statsdsample", 1),
+		AutoEject:       c.DefaultBool("backend::autoeject", false),
+		FailureLimit:    c.DefaultInt("backend::failurelimit", 2),
+		RetryTimeout:    c.DefaultInt64("backend::retrytimeout", 30000),
+		DialTimeout:     c.DefaultInt64("backend::dialtimeout", 1000),
+		ReadTimeout:     c.DefaultInt64("backend::readtimeout", 3000),
+		WriteTimeout:    c.DefaultInt64("backend::writetimeout", 3000),
+		PoolTimeout:     c.DefaultInt64("backend::pooltimeout", 1000),
+		IdleTimeout:     c.DefaultInt64("backend::idletimeout", 0),
+		MaxRedirects:    c.DefaultInt("backend::maxredirects", 16),
+		MaxRetries:      c.DefaultInt("backend::maxretries", 0),
+		MuxConns:        c.DefaultInt("backend::multiplex", 0),
+		RefreshInterval: c.DefaultInt64("backend::refreshinterval", 10000),
+		SlowTimeout:     c.DefaultInt64("backend::slowtimeout", 10000),
+		RetryBudget:     c.DefaultInt64("backend::retrybudget", 3000),
+		Breaker:         c.DefaultBool("backend::breaker", true),
+		BreakerRate:     c.DefaultInt("backend::breakerrate", 50),
+		BreakerSlow:     c.DefaultInt64("backend::breakerslow", 0),
+		BreakerCooldown: c.DefaultInt64("backend::breakercooldown", 5000),
+		MirrorPercent:   c.DefaultInt("mirror::percent", 10),
+		MirrorReadOnly:  c.DefaultBool("mirror::readonly", true),
+		MirrorCompare:   c.DefaultBool("mirror::compare", false),
+		MirrorQueue:     c.DefaultInt("mirror::queue", 10000),
+		Cache:           c.DefaultBool("cache::enable", false),
+		CacheTTL:        c.DefaultInt64("cache::ttl", 1000),
+		CacheMemory:     c.DefaultInt64("cache::maxmemory", 64),
+		CacheHotQps:     c.DefaultInt("cache::hotqps", 1000),
+		KeyStats:        c.DefaultBool("keystats::enable", false),
+		KeyStatsPercent: c.DefaultInt("keystats::percent", 10),
+		KeyStatsTopK:    c.DefaultInt("keystats::topk", 100),
+		HotKeyQps:       c.DefaultInt64("keystats::hotqps", 5000),
+		BigKeySize:      c.DefaultInt64("keystats::bigsize", 1<<20),
+		Coalesce:        c.DefaultBool("coalesce::enable", false),
+		CoalesceWaiters: c.DefaultInt("coalesce::maxwaiters", 1000),
 		FileName:        filename,
 	}
 
 	pc.Config = c
 
-	nodes := c.DefaultString("proxy::nodes", "")
-	if nodes == "" {
-		log.Fatal("proxy nodes must not empty ")
+	if err := pc.loadBackend(c, "backend"); err != nil {
+		return nil, err
+	}
+
+	slow := c.DefaultString("backend::slowcommands", "DUMP,RESTORE")
+	for _, name := range strings.Split(slow, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			pc.SlowCommands = append(pc.SlowCommands, strings.ToUpper(name))
+		}
+	}
+
+	if err := pc.loadCommands(c); err != nil {
+		return nil, err
 	}
-	pc.Nodes = strings.Split(nodes, ",")
 
 	if pc.Id == "" || pc.Name == "" || pc.Port == "" {
-		log.Fatal("id name or port must not empty")
+		return nil, errors.New("id name or port must not empty")
+	}
+
+	if pc.RegistryType == "" && pc.Zk != "" {
+		pc.RegistryType = RegistryZk
+	}
+	switch pc.RegistryType {
+	case "":
+	case RegistryZk:
+		if pc.Zk == "" || pc.ZkPath == "" {
+			return nil, errors.New("zk::zk and zk::zkpath must not empty")
+		}
+	case RegistryFile:
+		if pc.RegistryPath == "" {
+			return nil, errors.New("registry::path must not empty")
+		}
+	default:
+		return nil, fmt.Errorf("unknown registry type %s", pc.RegistryType)
+	}
+
+	if pc.Cpus < 1 {
+		log.Info("Adjust Cpus to 4")
+		pc.Cpus = 4
+	}
+
+	for _, key := range strings.Split(c.DefaultString("blacklist::keys", ""), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			pc.BlackKeys = append(pc.BlackKeys, key)
+		}
+	}
+
+	switch pc.SlaveSelect {
+	case redis.SlaveSelectRandom, redis.SlaveSelectRoundRobin, redis.SlaveSelectLatency:
+	default:
+		log.Info("Adjust SlaveSelect to random")
+		pc.SlaveSelect = redis.SlaveSelectRandom
 	}
 
 	if pc.PoolSizePerNode < MinPoolSizePerNode || pc.PoolSizePerNode > MaxPoolSizePerNode {
@@ -102,45 +319,459 @@ func NewProxyConfig(filename string) *ProxyConfig {
 		pc.IdleTime = 300
 	}
 
-	fcpu := c.DefaultString("debug::cpufile", "")
-	if fcpu != "" {
-		f, err := os.Create(fcpu)
-		if err != nil {
-			log.Fatal(err)
+	if pc.GraceTime < MinGraceTime || pc.GraceTime > MaxGraceTime {
+		log.Info("Adjust GraceTime to 30")
+		pc.GraceTime = 30
+	}
+
+	for _, name := range BackendOptionNames {
+		if err := pc.SetBackendByName(name, pc.BackendByName(name)); err != nil {
+			log.Infof("Adjust %s to default, %s", name, err)
+			pc.SetBackendByName(name, backendDefaults[name])
 		}
-		pprof.StartCPUProfile(f)
-		defer pprof.StopCPUProfile()
 	}
 
-	fmem := c.DefaultString("debug::memfile", "")
-	if fmem != "" {
-		f, err := os.Create(fmem)
-		if err != nil {
-			log.Fatal(err)
+	for _, tag := range strings.Split(c.DefaultString("proxy::statsdtags", ""), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			pc.StatsdTags = append(pc.StatsdTags, tag)
 		}
-		pprof.WriteHeapProfile(f)
 	}
-	return pc
+
+	if pc.StatsdSample <= 0 || pc.StatsdSample > 1 {
+		log.Info("Adjust StatsdSample to 1")
+		pc.StatsdSample = 1
+	}
+
+	if pc.MuxConns < MinMuxConns || pc.MuxConns > MaxMuxConns {
+		log.Info("Adjust MuxConns to 0")
+		pc.MuxConns = 0
+	}
+
+	if pc.RefreshInterval != 0 && (pc.RefreshInterval < MinRefreshInterval || pc.RefreshInterval > MaxRefreshInterval) {
+		log.Info("Adjust RefreshInterval to 10000")
+		pc.RefreshInterval = 10000
+	}
+
+	if pc.RetryBudget < MinRetryBudget || pc.RetryBudget > MaxRetryBudget {
+		log.Info("Adjust RetryBudget to 3000")
+		pc.RetryBudget = 3000
+	}
+
+	if pc.BreakerRate < MinBreakerRate || pc.BreakerRate > MaxBreakerRate {
+		log.Info("Adjust BreakerRate to 50")
+		pc.BreakerRate = 50
+	}
+
+	if pc.BreakerSlow < 0 {
+		log.Info("Adjust BreakerSlow to 0")
+		pc.BreakerSlow = 0
+	}
+
+	if pc.BreakerCooldown < MinBreakerCooldown || pc.BreakerCooldown > MaxBreakerCooldown {
+		log.Info("Adjust BreakerCooldown to 5000")
+		pc.BreakerCooldown = 5000
+	}
+
+	if pc.FailureLimit < MinFailureLimit || pc.FailureLimit > MaxFailureLimit {
+		log.Info("Adjust FailureLimit to 2")
+		pc.FailureLimit = 2
+	}
+
+	if pc.RetryTimeout < MinRetryTimeout || pc.RetryTimeout > MaxRetryTimeout {
+		log.Info("Adjust RetryTimeout to 30000")
+		pc.RetryTimeout = 30000
+	}
+
+	if nodes := c.DefaultString("mirror::nodes", ""); nodes != "" {
+		pc.MirrorNodes = strings.Split(nodes, ",")
+	}
+
+	if pc.MirrorPercent < MinMirrorPercent || pc.MirrorPercent > MaxMirrorPercent {
+		log.Info("Adjust MirrorPercent to 10")
+		pc.MirrorPercent = 10
+	}
+
+	if pc.MirrorQueue < MinMirrorQueue || pc.MirrorQueue > MaxMirrorQueue {
+		log.Info("Adjust MirrorQueue to 10000")
+		pc.MirrorQueue = 10000
+	}
+
+	for _, pattern := range strings.Split(c.DefaultString("cache::patterns", ""), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			pc.CachePatterns = append(pc.CachePatterns, pattern)
+		}
+	}
+
+	if pc.CacheTTL < MinCacheTTL || pc.CacheTTL > MaxCacheTTL {
+		log.Info("Adjust CacheTTL to 1000")
+		pc.CacheTTL = 1000
+	}
+
+	if pc.CacheMemory < MinCacheMemory || pc.CacheMemory > MaxCacheMemory {
+		log.Info("Adjust CacheMemory to 64")
+		pc.CacheMemory = 64
+	}
+
+	if pc.CacheHotQps < 0 {
+		log.Info("Adjust CacheHotQps to 0")
+		pc.CacheHotQps = 0
+	}
+
+	if pc.KeyStatsPercent < MinKeyStatsPercent || pc.KeyStatsPercent > MaxKeyStatsPercent {
+		log.Info("Adjust KeyStatsPercent to 10")
+		pc.KeyStatsPercent = 10
+	}
+
+	if pc.KeyStatsTopK < MinKeyStatsTopK || pc.KeyStatsTopK > MaxKeyStatsTopK {
+		log.Info("Adjust KeyStatsTopK to 100")
+		pc.KeyStatsTopK = 100
+	}
+
+	commands := c.DefaultString("coalesce::commands", "GET,HGET,HGETALL,SMEMBERS,LRANGE,ZRANGE")
+	for _, name := range strings.Split(commands, ",") {
+		if name = strings.ToUpper(strings.TrimSpace(name)); name == "" {
+			continue
+		}
+		if !redis.IsReadOnly(name) {
+			log.Warning("coalesce command must be read only, ignore ", name)
+			continue
+		}
+		pc.CoalesceCommands = append(pc.CoalesceCommands, name)
+	}
+
+	if pc.CoalesceWaiters < MinCoalesceWaiters || pc.CoalesceWaiters > MaxCoalesceWaiters {
+		log.Info("Adjust CoalesceWaiters to 1000")
+		pc.CoalesceWaiters = 1000
+	}
+
+	// secondary shares pool and timeouts options
+	if c.DefaultString("migrate::type", "") != "" {
+		secondary := *pc
+		if err := secondary.loadBackend(c, "migrate"); err != nil {
+			return nil, err
+		}
+		pc.Secondary = &secondary
+		pc.MigratePhase = c.DefaultString("migrate::phase", MigrateOff)
+		pc.MigrateAsync = c.DefaultBool("migrate::async", true)
+		if !isMigratePhase(pc.MigratePhase) {
+			log.Info("Adjust MigratePhase to off")
+			pc.MigratePhase = MigrateOff
+		}
+	}
+
+	return pc, nil
+}
+
+// BackendOptionNames can be changed by PROXY CONFIG SET at runtime
+var BackendOptionNames = []string{
+	"dialtimeout", "readtimeout", "writetimeout", "pooltimeout",
+	"idletimeout", "maxredirects", "maxretries", "slowtimeout",
+}
+
+var backendDefaults = map[string]int64{
+	"dialtimeout":  1000,
+	"readtimeout":  3000,
+	"writetimeout": 3000,
+	"pooltimeout":  1000,
+	"idletimeout":  0,
+	"maxredirects": 16,
+	"maxretries":   0,
+	"slowtimeout":  10000,
+}
+
+func (pc *ProxyConfig) BackendByName(name string) int64 {
+	switch name {
+	case "dialtimeout":
+		return pc.DialTimeout
+	case "readtimeout":
+		return pc.ReadTimeout
+	case "writetimeout":
+		return pc.WriteTimeout
+	case "pooltimeout":
+		return pc.PoolTimeout
+	case "idletimeout":
+		return pc.IdleTimeout
+	case "maxredirects":
+		return int64(pc.MaxRedirects)
+	case "maxretries":
+		return int64(pc.MaxRetries)
+	case "slowtimeout":
+		return pc.SlowTimeout
+	}
+	return 0
+}
+
+// SetBackendByName validates v and sets backend option name to it
+func (pc *ProxyConfig) SetBackendByName(name string, v int64) error {
+	var min, max int64
+	switch name {
+	case "dialtimeout", "readtimeout", "writetimeout", "pooltimeout":
+		min, max = MinBackendTimeout, MaxBackendTimeout
+	case "idletimeout":
+		min, max = MinIdleTimeout, MaxIdleTimeout
+	case "maxredirects":
+		min, max = MinMaxRedirects, MaxMaxRedirects
+	case "maxretries":
+		min, max = MinMaxRetries, MaxMaxRetries
+	case "slowtimeout":
+		min, max = MinSlowTimeout, MaxSlowTimeout
+	default:
+		return fmt.Errorf("unknown backend option %s", name)
+	}
+	if v < min || v > max {
+		return fmt.Errorf("unavailable %s, must between %d ~ %d", name, min, max)
+	}
+
+	switch name {
+	case "dialtimeout":
+		pc.DialTimeout = v
+	case "readtimeout":
+		pc.ReadTimeout = v
+	case "writetimeout":
+		pc.WriteTimeout = v
+	case "pooltimeout":
+		pc.PoolTimeout = v
+	case "idletimeout":
+		pc.IdleTimeout = v
+	case "maxredirects":
+		pc.MaxRedirects = int(v)
+	case "maxretries":
+		pc.MaxRetries = int(v)
+	case "slowtimeout":
+		pc.SlowTimeout = v
+	}
+	return nil
+}
+
+// ClusterOptions builds backend options from config
+func (pc *ProxyConfig) ClusterOptions() *redis.ClusterOptions {
+	ms := time.Millisecond
+	opt := &redis.ClusterOptions{
+		Addrs:        pc.Nodes,
+		PoolSize:     pc.PoolSizePerNode,
+		SlaveOk:      pc.SlaveOk,
+		SlaveSelect:  pc.SlaveSelect,
+		MaxRedirects: pc.MaxRedirects,
+		MaxRetries:   pc.MaxRetries,
+		MuxConns:     pc.MuxConns,
+
+		DialTimeout:  time.Duration(pc.DialTimeout) * ms,
+		ReadTimeout:  time.Duration(pc.ReadTimeout) * ms,
+		WriteTimeout: time.Duration(pc.WriteTimeout) * ms,
+		PoolTimeout:  time.Duration(pc.PoolTimeout) * ms,
+		IdleTimeout:  time.Duration(pc.IdleTimeout) * ms,
+
+		RefreshInterval: time.Duration(pc.RefreshInterval) * ms,
+
+		CommandTimeouts: make(map[string]time.Duration, len(pc.SlowCommands)),
+
+		RetryBudget: time.Duration(pc.RetryBudget) * ms,
+
+		BreakerErrorRate: float64(pc.BreakerRate) / 100,
+		BreakerSlowTime:  time.Duration(pc.BreakerSlow) * ms,
+		BreakerCooldown:  time.Duration(pc.BreakerCooldown) * ms,
+	}
+	for _, name := range pc.SlowCommands {
+		opt.CommandTimeouts[name] = time.Duration(pc.SlowTimeout) * ms
+	}
+	if !pc.Breaker {
+		opt.BreakerErrorRate = -1
+	}
+	return opt
+}
+
+// loadBackend reads backend type and its addrs from section, cluster
+// nodes of [backend] are in proxy::nodes as before
+func (pc *ProxyConfig) loadBackend(c config.ConfigContainer, section string) error {
+	key := func(name string) string {
+		return section + "::" + name
+	}
+	pc.BackendType = c.DefaultString(key("type"), BackendCluster)
+	pc.MasterName = c.DefaultString(key("mastername"), "")
+	pc.Distribution = c.DefaultString(key("distribution"), redis.RingKetama)
+	pc.Hash = c.DefaultString(key("hash"), redis.RingFnv1a64)
+	pc.HashTag = c.DefaultString(key("hashtag"), "")
+	pc.Nodes, pc.Addr, pc.Sentinels, pc.Servers = nil, "", nil, nil
+
+	switch pc.BackendType {
+	case BackendCluster:
+		nodesKey := key("nodes")
+		if section == "backend" {
+			nodesKey = "proxy::nodes"
+		}
+		nodes := c.DefaultString(nodesKey, "")
+		if nodes == "" {
+			return fmt.Errorf("%s must not empty", nodesKey)
+		}
+		pc.Nodes = strings.Split(nodes, ",")
+	case BackendSingle:
+		pc.Addr = c.DefaultString(key("addr"), "")
+		if pc.Addr == "" {
+			return fmt.Errorf("%s must not empty", key("addr"))
+		}
+	case BackendSentinel:
+		sentinels := c.DefaultString(key("sentinels"), "")
+		if sentinels == "" || pc.MasterName == "" {
+			return fmt.Errorf("%s and %s must not empty", key("sentinels"), key("mastername"))
+		}
+		pc.Sentinels = strings.Split(sentinels, ",")
+	case BackendRing:
+		servers := c.DefaultString(key("servers"), "")
+		if servers == "" {
+			return fmt.Errorf("%s must not empty", key("servers"))
+		}
+		for _, server := range strings.Split(servers, ",") {
+			shard, err := parseRingServer(server)
+			if err != nil {
+				return err
+			}
+			pc.Servers = append(pc.Servers, shard)
+		}
+		if pc.HashTag != "" && len(pc.HashTag) != 2 {
+			return fmt.Errorf("%s must be 2 chars like {}", key("hashtag"))
+		}
+	default:
+		return fmt.Errorf("unknown backend type %s", pc.BackendType)
+	}
+	return nil
+}
+
+// loadCommands reads [commands], rename is like FLUSHALL:name,CONFIG:
+// and an empty name disables the command
+func (pc *ProxyConfig) loadCommands(c config.ConfigContainer) error {
+	names := func(key string) []string {
+		var list []string
+		for _, name := range strings.Split(c.DefaultString(key, ""), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				list = append(list, strings.ToUpper(name))
+			}
+		}
+		return list
+	}
+	pc.DenyCommands = names("commands::deny")
+	pc.AllowCommands = names("commands::allow")
+	pc.RenameCommands = make(map[string]string)
+	for _, pair := range names("commands::rename") {
+		i := strings.Index(pair, ":")
+		if i <= 0 {
+			return fmt.Errorf("bad commands::rename %s, should be command:name", pair)
+		}
+		pc.RenameCommands[pair[:i]] = strings.TrimSpace(pair[i+1:])
+	}
+	// same check as runtime
+	_, err := NewCommands(pc.DenyCommands, pc.AllowCommands, pc.RenameCommands)
+	return err
+}
+
+// parseRingServer parses twemproxy style server "host:port:weight name",
+// name defaults to host:port, or host if port is 11211 like twemproxy.
+func parseRingServer(server string) (redis.RingShard, error) {
+	var shard redis.RingShard
+	fields := strings.Fields(server)
+	if len(fields) == 0 || len(fields) > 2 {
+		return shard, fmt.Errorf("invalid ring server %q", server)
+	}
+	if len(fields) == 2 {
+		shard.Name = fields[1]
+	}
+
+	parts := strings.Split(fields[0], ":")
+	if len(parts) != 3 {
+		return shard, fmt.Errorf("invalid ring server %q, want host:port:weight", server)
+	}
+	weight, err := strconv.Atoi(parts[2])
+	if err != nil || weight <= 0 {
+		return shard, fmt.Errorf("invalid ring server %q, bad weight", server)
+	}
+	shard.Addr = net.JoinHostPort(parts[0], parts[1])
+	shard.Weight = weight
+	if shard.Name == "" {
+		shard.Name = shard.Addr
+		if parts[1] == "11211" {
+			shard.Name = parts[0]
+		}
+	}
+	return shard, nil
+}
+
+// RingOptions builds ring backend options from config
+func (pc *ProxyConfig) RingOptions() *redis.RingOptions {
+	ms := time.Millisecond
+	return &redis.RingOptions{
+		Shards:       pc.Servers,
+		Distribution: pc.Distribution,
+		Hash:         pc.Hash,
+		HashTag:      pc.HashTag,
+		AutoEject:    pc.AutoEject,
+		FailureLimit: pc.FailureLimit,
+		RetryTimeout: time.Duration(pc.RetryTimeout) * ms,
+		PoolSize:     pc.PoolSizePerNode,
+		MaxRetries:   pc.MaxRetries,
+
+		DialTimeout:  time.Duration(pc.DialTimeout) * ms,
+		ReadTimeout:  time.Duration(pc.ReadTimeout) * ms,
+		WriteTimeout: time.Duration(pc.WriteTimeout) * ms,
+		PoolTimeout:  time.Duration(pc.PoolTimeout) * ms,
+		IdleTimeout:  time.Duration(pc.IdleTimeout) * ms,
+	}
+}
+
+// MirrorOptions builds shadow cluster options from config, others
+// are the same as backend
+func (pc *ProxyConfig) MirrorOptions() *redis.ClusterOptions {
+	opt := pc.ClusterOptions()
+	opt.Addrs = pc.MirrorNodes
+	opt.SlaveOk = false
+	return opt
+}
+
+// SingleOptions builds single redis backend options from config
+func (pc *ProxyConfig) SingleOptions() *redis.Options {
+	ms := time.Millisecond
+	return &redis.Options{
+		Addr:       pc.Addr,
+		PoolSize:   pc.PoolSizePerNode,
+		MaxRetries: pc.MaxRetries,
+		MuxConns:   pc.MuxConns,
+
+		DialTimeout:  time.Duration(pc.DialTimeout) * ms,
+		ReadTimeout:  time.Duration(pc.ReadTimeout) * ms,
+		WriteTimeout: time.Duration(pc.WriteTimeout) * ms,
+		PoolTimeout:  time.Duration(pc.PoolTimeout) * ms,
+		IdleTimeout:  time.Duration(pc.IdleTimeout) * ms,
+	}
+}
+
+// FailoverOptions builds sentinel backend options from config
+func (pc *ProxyConfig) FailoverOptions() *redis.FailoverOptions {
+	ms := time.Millisecond
+	return &redis.FailoverOptions{
+		MasterName:    pc.MasterName,
+		SentinelAddrs: pc.Sentinels,
+		PoolSize:      pc.PoolSizePerNode,
+		MaxRetries:    pc.MaxRetries,
+		SlaveOk:       pc.SlaveOk,
+
+		DialTimeout:  time.Duration(pc.DialTimeout) * ms,
+		ReadTimeout:  time.Duration(pc.ReadTimeout) * ms,
+		WriteTimeout: time.Duration(pc.WriteTimeout) * ms,
+		PoolTimeout:  time.Duration(pc.PoolTimeout) * ms,
+		IdleTimeout:  time.Duration(pc.IdleTimeout) * ms,
+	}
 }
 
 func (ps *ProxyServer) SaveConfigToFile() {
-	ticker := time.NewTicker(3600 * time.Second)
+	if ps.Conf.BackendType != BackendCluster {
+		// only cluster finds nodes by itself
+		return
+	}
+
+	ticker := time.NewTicker(60 * time.Second)
 	for {
 		select {
 		case <-ticker.C:
-			newaddr := ps.Backend.GetAddrs()
-			oldaddr := ps.Conf.Nodes
-			if (len(newaddr) != len(oldaddr)) && (len(newaddr) != 0) {
-
-				ps.Conf.Nodes = newaddr
-				// persistent nodes info
-				nodes := strings.Join(newaddr, ",")
-				log.Warning("addr changed to ", nodes)
-				ps.Conf.Config.Set("proxy::nodes", nodes)
-				err := ps.Conf.Config.SaveConfigFile(ps.Conf.FileName)
-				if err != nil {
-					log.Warning("persistent config failed ", err)
-				}
+			if err := ps.saveNodes(); err != nil {
+				log.Warning("persistent config failed ", err)
 			}
 		case <-ps.Quit:
 			goto quit
@@ -150,3 +781,181 @@ func (ps *ProxyServer) SaveConfigToFile() {
 quit:
 	log.Warning("quit SaveConfigToFile...")
 }
+
+// saveNodes persists nodes of backend if they changed
+func (ps *ProxyServer) saveNodes() error {
+	ps.confLock.Lock()
+	defer ps.confLock.Unlock()
+	newaddr := ps.Backend.Nodes()
+	oldaddr := ps.Conf.Nodes
+	if sameAddrs(newaddr, oldaddr) || len(newaddr) == 0 {
+		return nil
+	}
+
+	ps.Conf.Nodes = newaddr
+	nodes := strings.Join(newaddr, ",")
+	log.Warning("addr changed to ", nodes)
+	ps.Conf.Config.Set("proxy::nodes", nodes)
+	return saveConfigFile(ps.Conf.Config, ps.Conf.FileName)
+}
+
+// saveConfigFile writes c to a temp file and renames it, so file is
+// never partially written
+func saveConfigFile(c config.ConfigContainer, filename string) error {
+	tmp := filename + ".tmp"
+	if err := c.SaveConfigFile(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// sameAddrs reports whether a and b have the same addrs in any order
+func sameAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]int, len(a))
+	for _, addr := range a {
+		seen[addr]++
+	}
+	for _, addr := range b {
+		if seen[addr] == 0 {
+			return false
+		}
+		seen[addr]--
+	}
+	return true
+}
+
+// ConfigGet returns runtime option name, value is string or int64
+func (ps *ProxyServer) ConfigGet(name string) (interface{}, error) {
+	switch name {
+	case "loglevel":
+		t := log.GetLogLevel()
+		s, _ := log.LogTypeToString(log.LogType(t))
+		return s, nil
+	case "idletime":
+		return ps.Conf.IdleTime, nil
+	case "maxconn":
+		return ps.Conf.MaxConn, nil
+	case "slaveok":
+		if ps.Conf.SlaveOk {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case "mulparallel":
+		return int64(ps.Conf.MulOpParallel), nil
+	case "statsd":
+		return ps.Conf.Statsd, nil
+	case "dialtimeout", "readtimeout", "writetimeout", "pooltimeout",
+		"idletimeout", "maxredirects", "maxretries", "slowtimeout":
+		return ps.Conf.BackendByName(name), nil
+	}
+	return nil, errors.New("wrong proxy config name")
+}
+
+// ConfigSet validates value and sets runtime option name to it,
+// it returns old value
+func (ps *ProxyServer) ConfigSet(name string, value string) (interface{}, error) {
+	ps.confLock.Lock()
+	defer ps.confLock.Unlock()
+	return ps.configSet(name, value)
+}
+
+// configSet is ConfigSet, ps.confLock must be held
+func (ps *ProxyServer) configSet(name string, value string) (interface{}, error) {
+	switch name {
+	case "loglevel":
+		v := strings.ToLower(value)
+		if v != "info" && v != "warning" && v != "debug" {
+			return nil, errors.New("loglevel must be info warning or debug")
+		}
+		old, _ := ps.ConfigGet(name)
+		ps.Conf.LogLevel = v
+		log.SetLevelByString(v)
+		return old, nil
+	case "idletime":
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.New("unavailable idletime")
+		}
+		if v < MinIdleTime || v > MaxIdleTime {
+			return nil, errors.New("unavailable idletime, must between 10 ~ 300")
+		}
+		old := ps.Conf.IdleTime
+		ps.Conf.IdleTime = int64(v)
+		return old, nil
+	case "slaveok":
+		v, err := strconv.Atoi(value)
+		if err != nil || (v != 0 && v != 1) {
+			return nil, errors.New("unavailable slaveok,must 0 or 1")
+		}
+		old, _ := ps.ConfigGet(name)
+		ps.Conf.SlaveOk = v == 1
+		ps.Backend.SetSlaveOk(ps.Conf.SlaveOk)
+		return old, nil
+	case "mulparallel":
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.New("unavailable mulparallel")
+		}
+		if v < MinMulOpParallel || v > MaxMulOpParallel {
+			return nil, errors.New("unavailable mulparallel, must between 5 ~ 100")
+		}
+		old := int64(ps.Conf.MulOpParallel)
+		ps.Conf.MulOpParallel = v
+		return old, nil
+	case "statsd":
+		old := ps.Conf.Statsd
+		ps.Conf.Statsd = value
+		ps.Statsd.SetAddr(value)
+		return old, nil
+	case "maxconn":
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.New("unavailable maxconn")
+		}
+		if v < MinMaxConn || v > MaxMaxConn {
+			return nil, errors.New("unavailable maxconn, must between 100 ~ 60000")
+		}
+		old := ps.Conf.MaxConn
+		ps.Conf.MaxConn = int64(v)
+		return old, nil
+	case "dialtimeout", "readtimeout", "writetimeout", "pooltimeout",
+		"idletimeout", "maxredirects", "maxretries", "slowtimeout":
+		b, ok := ps.Backend.(tunableBackend)
+		if !ok {
+			return nil, fmt.Errorf("%s can not be set at runtime for %s backend", name, ps.Conf.BackendType)
+		}
+		// redirects and slow commands are of redis cluster only
+		if _, cluster := b.(*clusterBackend); !cluster && (name == "maxredirects" || name == "slowtimeout") {
+			return nil, fmt.Errorf("%s is for cluster backend only", name)
+		}
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unavailable %s", name)
+		}
+		old := ps.Conf.BackendByName(name)
+		if err := ps.Conf.SetBackendByName(name, v); err != nil {
+			return nil, err
+		}
+		ps.setBackendOptions()
+		return old, nil
+	}
+	return nil, errors.New("wrong proxy config name")
+}
+
+// setBackendOptions applies retries and timeouts of config to backend
+// and mirror shadow, only new commands and connections see the change.
+// ps.confLock must be held.
+func (ps *ProxyServer) setBackendOptions() {
+	if b, ok := ps.Backend.(tunableBackend); ok {
+		b.setOptions(ps.Conf)
+	}
+	if ps.Mirror != nil {
+		if shadow, ok := ps.Mirror.Shadow.(tunableBackend); ok {
+			shadow.setOptions(ps.Conf)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package smartproxy
+
+import (
+	"testing"
+
+	"github.com/dongzerun/smartproxy/redis"
+)
+
+// tunedBackend records options set at runtime
+type tunedBackend struct {
+	*fakeBackend
+	readTimeout int64
+}
+
+func (b *tunedBackend) setOptions(pc *ProxyConfig) {
+	b.readTimeout = pc.ReadTimeout
+}
+
+func TestConfigSetBackendOptions(t *testing.T) {
+	// nothing listens, options are set without connecting
+	down := "127.0.0.1:1"
+	conf := &ProxyConfig{
+		Nodes:      []string{down},
+		Addr:       down,
+		MasterName: "mymaster",
+		Sentinels:  []string{down},
+		Servers:    []redis.RingShard{{Name: "redis1", Addr: down, Weight: 1}},
+	}
+	for _, typ := range []string{BackendCluster, BackendSingle, BackendSentinel, BackendRing} {
+		conf.BackendType = typ
+		backend, err := NewBackend(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps := newFakeProxy(backend)
+		ps.Conf.BackendType = typ
+		shadow := &tunedBackend{fakeBackend: newFakeBackend()}
+		ps.Mirror = NewMirror(shadow, 0, false, false, 1)
+
+		if _, err := ps.ConfigSet("readtimeout", "500"); err != nil {
+			t.Errorf("%s: set readtimeout got %v", typ, err)
+		}
+		if ps.Conf.ReadTimeout != 500 || shadow.readTimeout != 500 {
+			t.Errorf("%s: readtimeout is %d, %d on mirror, want 500", typ, ps.Conf.ReadTimeout, shadow.readTimeout)
+		}
+		_, err = ps.ConfigSet("maxredirects", "5")
+		if cluster := typ == BackendCluster; (err == nil) != cluster {
+			t.Errorf("%s: set maxredirects got %v", typ, err)
+		}
+		ps.Mirror.Close()
+		backend.Close()
+	}
+
+	ps := newFakeProxy(newFakeBackend())
+	if _, err := ps.ConfigSet("readtimeout", "500"); err == nil {
+		t.Error("set readtimeout of fake backend got no error")
+	}
+}
@@ -12,4 +12,78 @@ const (
 
 	MinIdleTime = 5
 	MaxIdleTime = 300
+
+	// seconds
+	MinGraceTime = 0
+	MaxGraceTime = 600
+
+	MinRetryBudget = 0
+	MaxRetryBudget = 30000
+
+	MinBreakerRate = 1
+	MaxBreakerRate = 100
+
+	MinBreakerCooldown = 100
+	MaxBreakerCooldown = 60000
+
+	// backend timeouts in ms
+	MinBackendTimeout = 10
+	MaxBackendTimeout = 60000
+
+	MinIdleTimeout = 0
+	MaxIdleTimeout = 3600000
+
+	MinSlowTimeout = 10
+	MaxSlowTimeout = 600000
+
+	MinMaxRedirects = 1
+	MaxMaxRedirects = 64
+
+	MinMaxRetries = 0
+	MaxMaxRetries = 10
+
+	MinMuxConns = 0
+	MaxMuxConns = 16
+
+	// ms, 0 disables
+	MinRefreshInterval = 1000
+	MaxRefreshInterval = 3600000
+
+	MaxSlot = 16383
+
+	// backend types
+	BackendCluster  = "cluster"
+	BackendSingle   = "single"
+	BackendSentinel = "sentinel"
+	BackendRing     = "ring"
+
+	MinFailureLimit = 1
+	MaxFailureLimit = 100
+
+	// ms
+	MinRetryTimeout = 100
+	MaxRetryTimeout = 3600000
+
+	MinMirrorPercent = 0
+	MaxMirrorPercent = 100
+
+	MinMirrorQueue = 100
+	MaxMirrorQueue = 1000000
+
+	// ms
+	MinCacheTTL = 10
+	MaxCacheTTL = 60000
+
+	// MB
+	MinCacheMemory = 1
+	MaxCacheMemory = 4096
+
+	MinKeyStatsPercent = 1
+	MaxKeyStatsPercent = 100
+
+	MinKeyStatsTopK = 10
+	MaxKeyStatsTopK = 1000
+
+	MinCoalesceWaiters = 1
+	MaxCoalesceWaiters = 100000
 )
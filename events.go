@@ -0,0 +1,72 @@
+package smartproxy
+
+import (
+	"sync"
+
+	"github.com/dongzerun/smartproxy/redis"
+	log "github.com/ngaut/logging"
+)
+
+// eventsSize is how many topology events PROXY EVENTS keeps
+const eventsSize = 256
+
+// Events keeps recent cluster topology events in a ring buffer and
+// counts them by type for statsd
+type Events struct {
+	lock   sync.Mutex
+	ring   []redis.ClusterEvent
+	next   int // index of next event in ring
+	full   bool
+	counts map[string]int64
+}
+
+func NewEvents(size int) *Events {
+	return &Events{
+		ring:   make([]redis.ClusterEvent, size),
+		counts: make(map[string]int64),
+	}
+}
+
+// Add logs and keeps e
+func (ev *Events) Add(e redis.ClusterEvent) {
+	log.Warning("cluster event: ", e)
+
+	ev.lock.Lock()
+	ev.ring[ev.next] = e
+	ev.next = (ev.next + 1) % len(ev.ring)
+	if ev.next == 0 {
+		ev.full = true
+	}
+	ev.counts[e.Type]++
+	ev.lock.Unlock()
+}
+
+// Last returns at most n events, newest first
+func (ev *Events) Last(n int) []redis.ClusterEvent {
+	ev.lock.Lock()
+	defer ev.lock.Unlock()
+
+	size := ev.next
+	if ev.full {
+		size = len(ev.ring)
+	}
+	if n > size {
+		n = size
+	}
+	r := make([]redis.ClusterEvent, 0, n)
+	for i := 1; i <= n; i++ {
+		r = append(r, ev.ring[(ev.next-i+len(ev.ring))%len(ev.ring)])
+	}
+	return r
+}
+
+// Stats returns events by type
+func (ev *Events) Stats() map[string]int64 {
+	ev.lock.Lock()
+	defer ev.lock.Unlock()
+	stats := make(map[string]int64, len(ev.counts))
+	for typ, n := range ev.counts {
+		stats[typ] = n
+	}
+	return stats
+}
@@ -0,0 +1,206 @@
+package smartproxy
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dongzerun/smartproxy/redis"
+	log "github.com/ngaut/logging"
+)
+
+const (
+	// count-min sketch size
+	sketchDepth = 4
+	sketchWidth = 4096
+
+	// counts are reported per window
+	keyStatsWindow = 10 * time.Second
+)
+
+// KeyStat is a key with its estimated qps, or reply size of a big key
+type KeyStat struct {
+	Key   string
+	Cmd   string // command of big key reply
+	Value int64
+}
+
+// KeyStats samples requests to find hot keys and big replies in a
+// bounded space. Reads are counted in a count-min sketch, only topK
+// keys are kept. Hot keys are reported for the last complete window.
+type KeyStats struct {
+	percent int   // sampled requests, 1 ~ 100
+	topK    int   // keys kept
+	hotQps  int64 // warn if a key is read more often, 0 disabled
+	bigSize int64 // warn if a reply is larger, 0 disabled
+
+	lock   sync.Mutex
+	sketch [sketchDepth][]uint32
+	counts map[string]int64 // candidates of this window
+	window time.Time
+	hot    []KeyStat                   // last window, by qps desc
+	big    map[string]map[string]int64 // largest replies by command
+}
+
+func NewKeyStats(percent, topK int, hotQps, bigSize int64) *KeyStats {
+	ks := &KeyStats{
+		percent: percent,
+		topK:    topK,
+		hotQps:  hotQps,
+		bigSize: bigSize,
+		counts:  make(map[string]int64),
+		window:  time.Now(),
+		big:     make(map[string]map[string]int64),
+	}
+	for i := range ks.sketch {
+		ks.sketch[i] = make([]uint32, sketchWidth)
+	}
+	return ks
+}
+
+// Record samples req answered with reply
+func (ks *KeyStats) Record(req *redis.Request, reply []byte) {
+	args := req.Args()
+	if len(args) == 0 {
+		return
+	}
+	if ks.percent < 100 && rand.Intn(100) >= ks.percent {
+		return
+	}
+	key := args[0]
+
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	ks.rotate(time.Now())
+	ks.count(key)
+	ks.size(req.Name(), key, int64(len(reply)))
+}
+
+// count adds key to sketch, keeps key if it is among topK
+func (ks *KeyStats) count(key string) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)
+
+	var est uint32
+	for i := range ks.sketch {
+		cell := &ks.sketch[i][(h1+uint32(i)*h2)%sketchWidth]
+		*cell++
+		if i == 0 || *cell < est {
+			est = *cell
+		}
+	}
+
+	if _, ok := ks.counts[key]; ok || len(ks.counts) < ks.topK {
+		ks.counts[key] = int64(est)
+		return
+	}
+	minKey, min := minStat(ks.counts)
+	if int64(est) > min {
+		delete(ks.counts, minKey)
+		ks.counts[key] = int64(est)
+	}
+}
+
+// size keeps topK largest replies of each command
+func (ks *KeyStats) size(cmd, key string, n int64) {
+	sizes, ok := ks.big[cmd]
+	if !ok {
+		sizes = make(map[string]int64)
+		ks.big[cmd] = sizes
+	}
+	// warn once until size changes
+	if ks.bigSize > 0 && n >= ks.bigSize && sizes[key] != n {
+		log.Warningf("big key: %s %s reply %d bytes", cmd, key, n)
+	}
+	if _, ok := sizes[key]; ok || len(sizes) < ks.topK {
+		sizes[key] = n
+		return
+	}
+	minKey, min := minStat(sizes)
+	if n > min {
+		delete(sizes, minKey)
+		sizes[key] = n
+	}
+}
+
+// rotate ends window if it is over, counts become qps of hot keys
+func (ks *KeyStats) rotate(now time.Time) {
+	elapsed := now.Sub(ks.window)
+	if elapsed < keyStatsWindow {
+		return
+	}
+
+	hot := make([]KeyStat, 0, len(ks.counts))
+	for key, n := range ks.counts {
+		// sampled counts scaled to all requests
+		qps := n * 100 / int64(ks.percent) * int64(time.Second) / int64(elapsed)
+		hot = append(hot, KeyStat{Key: key, Value: qps})
+		if ks.hotQps > 0 && qps >= ks.hotQps {
+			log.Warningf("hot key: %s %d qps", key, qps)
+		}
+	}
+	sort.Sort(byValue(hot))
+	ks.hot = hot
+
+	for i := range ks.sketch {
+		for j := range ks.sketch[i] {
+			ks.sketch[i][j] = 0
+		}
+	}
+	ks.counts = make(map[string]int64)
+	ks.window = now
+}
+
+// HotKeys returns at most n keys by qps desc
+func (ks *KeyStats) HotKeys(n int) []KeyStat {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	ks.rotate(time.Now())
+	if n > len(ks.hot) {
+		n = len(ks.hot)
+	}
+	return append([]KeyStat(nil), ks.hot[:n]...)
+}
+
+// BigKeys returns at most n largest replies of all commands
+func (ks *KeyStats) BigKeys(n int) []KeyStat {
+	ks.lock.Lock()
+	big := make([]KeyStat, 0)
+	for cmd, sizes := range ks.big {
+		for key, size := range sizes {
+			big = append(big, KeyStat{Key: key, Cmd: cmd, Value: size})
+		}
+	}
+	ks.lock.Unlock()
+
+	sort.Sort(byValue(big))
+	if n > len(big) {
+		n = len(big)
+	}
+	return big[:n]
+}
+
+func minStat(m map[string]int64) (string, int64) {
+	var (
+		minKey string
+		min    int64 = -1
+	)
+	for k, n := range m {
+		if min < 0 || n < min {
+			minKey, min = k, n
+		}
+	}
+	return minKey, min
+}
+
+// byValue sorts KeyStat by value desc
+type byValue []KeyStat
+
+func (s byValue) Len() int           { return len(s) }
+func (s byValue) Less(i, j int) bool { return s[i].Value > s[j].Value }
+func (s byValue) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,227 @@
+package smartproxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are upper bounds of latency histograms in seconds
+var latencyBuckets = []float64{
+	.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5,
+}
+
+// histogram counts latencies in latencyBuckets with atomics, counts are
+// per bucket and the last one is +Inf
+type histogram struct {
+	counts []int64
+	sum    int64 // ns
+	count  int64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int64, len(latencyBuckets)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.SearchFloat64s(latencyBuckets, d.Seconds())
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+	atomic.AddInt64(&h.count, 1)
+}
+
+// requestMetrics are metrics of a command or a backend node
+type requestMetrics struct {
+	requests int64 // atomic
+	errors   int64 // atomic
+	latency  *histogram
+}
+
+func (r *requestMetrics) observe(d time.Duration, failed bool) {
+	atomic.AddInt64(&r.requests, 1)
+	if failed {
+		atomic.AddInt64(&r.errors, 1)
+	}
+	r.latency.observe(d)
+}
+
+// requestVec is requestMetrics by label value, created on first use
+type requestVec struct {
+	lock sync.RWMutex
+	m    map[string]*requestMetrics
+}
+
+func newRequestVec() *requestVec {
+	return &requestVec{m: make(map[string]*requestMetrics)}
+}
+
+func (v *requestVec) get(label string) *requestMetrics {
+	v.lock.RLock()
+	r, ok := v.m[label]
+	v.lock.RUnlock()
+	if ok {
+		return r
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if r, ok = v.m[label]; !ok {
+		r = &requestMetrics{latency: newHistogram()}
+		v.m[label] = r
+	}
+	return r
+}
+
+// each calls fn for each label value in order
+func (v *requestVec) each(fn func(label string, r *requestMetrics)) {
+	v.lock.RLock()
+	labels := make([]string, 0, len(v.m))
+	for label := range v.m {
+		labels = append(labels, label)
+	}
+	v.lock.RUnlock()
+
+	sort.Strings(labels)
+	for _, label := range labels {
+		fn(label, v.get(label))
+	}
+}
+
+// Metrics are in process collectors of /metrics
+type Metrics struct {
+	commands *requestVec // by command name
+	nodes    *requestVec // by backend node addr
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		commands: newRequestVec(),
+		nodes:    newRequestVec(),
+	}
+}
+
+// commandLabel returns name of known commands, unknown commands share
+// one label so clients can not blow up metric names
+func commandLabel(name string) string {
+	if _, ok := reqrules[name]; !ok {
+		return "OTHER"
+	}
+	return name
+}
+
+// ObserveCommand records a client request
+func (m *Metrics) ObserveCommand(name string, d time.Duration, failed bool) {
+	m.commands.get(commandLabel(name)).observe(d, failed)
+}
+
+// ObserveNode records a command processed by backend node, it is
+// redis.CommandHook
+func (m *Metrics) ObserveNode(addr string, d time.Duration, err error) {
+	m.nodes.get(addr).observe(d, err != nil)
+}
+
+// WriteMetrics writes metrics in prometheus text format
+func (ps *ProxyServer) WriteMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	m := ps.Metrics
+
+	writeRequestVec(bw, "smartproxy_command", "cmd", m.commands)
+	writeRequestVec(bw, "smartproxy_node", "node", m.nodes)
+
+	if b, ok := ps.Backend.(*clusterBackend); ok {
+		moved, asks := b.RedirectStats()
+		writeHeader(bw, "smartproxy_redirects_total", "counter", "MOVED and ASK redirects followed")
+		fmt.Fprintf(bw, "smartproxy_redirects_total{type=\"moved\"} %d\n", moved)
+		fmt.Fprintf(bw, "smartproxy_redirects_total{type=\"ask\"} %d\n", asks)
+	}
+
+	pools := ps.Backend.PoolStats()
+	addrs := make([]string, 0, len(pools))
+	for addr := range pools {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	writeHeader(bw, "smartproxy_pool_conns", "gauge", "open connections of node")
+	for _, addr := range addrs {
+		fmt.Fprintf(bw, "smartproxy_pool_conns{node=%s} %d\n", quoteLabel(addr), pools[addr].Conns)
+	}
+	writeHeader(bw, "smartproxy_pool_free", "gauge", "idle connections of node")
+	for _, addr := range addrs {
+		fmt.Fprintf(bw, "smartproxy_pool_free{node=%s} %d\n", quoteLabel(addr), pools[addr].Free)
+	}
+	writeHeader(bw, "smartproxy_pool_timeouts_total", "counter", "waits for free connection timed out")
+	for _, addr := range addrs {
+		fmt.Fprintf(bw, "smartproxy_pool_timeouts_total{node=%s} %d\n", quoteLabel(addr), pools[addr].Timeouts)
+	}
+
+	writeHeader(bw, "smartproxy_sessions", "gauge", "client connections")
+	fmt.Fprintf(bw, "smartproxy_sessions %d\n", ps.SessionCount())
+	writeHeader(bw, "smartproxy_uptime_seconds", "gauge", "seconds since proxy started")
+	fmt.Fprintf(bw, "smartproxy_uptime_seconds %d\n", int64(time.Since(ps.Startup)/time.Second))
+
+	writeRuntimeMetrics(bw)
+	return bw.Flush()
+}
+
+func writeRequestVec(w io.Writer, prefix, label string, v *requestVec) {
+	writeHeader(w, prefix+"_requests_total", "counter", "requests by "+label)
+	v.each(func(value string, r *requestMetrics) {
+		fmt.Fprintf(w, "%s_requests_total{%s=%s} %d\n", prefix, label, quoteLabel(value), atomic.LoadInt64(&r.requests))
+	})
+	writeHeader(w, prefix+"_errors_total", "counter", "failed requests by "+label)
+	v.each(func(value string, r *requestMetrics) {
+		fmt.Fprintf(w, "%s_errors_total{%s=%s} %d\n", prefix, label, quoteLabel(value), atomic.LoadInt64(&r.errors))
+	})
+
+	name := prefix + "_duration_seconds"
+	writeHeader(w, name, "histogram", "latency by "+label)
+	v.each(func(value string, r *requestMetrics) {
+		value = quoteLabel(value)
+		h := r.latency
+		var cumulative int64
+		for i, le := range latencyBuckets {
+			cumulative += atomic.LoadInt64(&h.counts[i])
+			fmt.Fprintf(w, "%s_bucket{%s=%s,le=\"%s\"} %d\n", name, label, value,
+				strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		cumulative += atomic.LoadInt64(&h.counts[len(latencyBuckets)])
+		fmt.Fprintf(w, "%s_bucket{%s=%s,le=\"+Inf\"} %d\n", name, label, value, cumulative)
+		fmt.Fprintf(w, "%s_sum{%s=%s} %g\n", name, label, value, time.Duration(atomic.LoadInt64(&h.sum)).Seconds())
+		fmt.Fprintf(w, "%s_count{%s=%s} %d\n", name, label, value, atomic.LoadInt64(&h.count))
+	})
+}
+
+func writeRuntimeMetrics(w io.Writer) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	writeHeader(w, "go_goroutines", "gauge", "number of goroutines")
+	fmt.Fprintf(w, "go_goroutines %d\n", runtime.NumGoroutine())
+	writeHeader(w, "go_memstats_alloc_bytes", "gauge", "bytes allocated and in use")
+	fmt.Fprintf(w, "go_memstats_alloc_bytes %d\n", mem.Alloc)
+	writeHeader(w, "go_memstats_sys_bytes", "gauge", "bytes obtained from system")
+	fmt.Fprintf(w, "go_memstats_sys_bytes %d\n", mem.Sys)
+	writeHeader(w, "go_memstats_heap_objects", "gauge", "allocated objects")
+	fmt.Fprintf(w, "go_memstats_heap_objects %d\n", mem.HeapObjects)
+	writeHeader(w, "go_gc_count_total", "counter", "completed GC cycles")
+	fmt.Fprintf(w, "go_gc_count_total %d\n", mem.NumGC)
+	writeHeader(w, "go_gc_pause_seconds_total", "counter", "GC stop the world pause")
+	fmt.Fprintf(w, "go_gc_pause_seconds_total %g\n", time.Duration(mem.PauseTotalNs).Seconds())
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(v string) string {
+	return `"` + labelReplacer.Replace(v) + `"`
+}
@@ -0,0 +1,179 @@
+package smartproxy
+
+import (
+	"bytes"
+	"sync/atomic"
+
+	"github.com/dongzerun/smartproxy/redis"
+	log "github.com/ngaut/logging"
+)
+
+// migrate phases
+const (
+	// primary only
+	MigrateOff = "off"
+	// writes go to both, reads from primary
+	MigrateDualWrite = "dualwrite"
+	// writes go to both, reads from secondary, primary on miss
+	MigrateReadSecondary = "readsecondary"
+)
+
+const (
+	migrateQueueSize = 10000
+	migrateWorkers   = 8
+)
+
+func isMigratePhase(phase string) bool {
+	switch phase {
+	case MigrateOff, MigrateDualWrite, MigrateReadSecondary:
+		return true
+	}
+	return false
+}
+
+// migrateWrite is a write waiting to be sent to secondary, with
+// reply of primary to compare
+type migrateWrite struct {
+	req   *redis.Request
+	reply []byte
+}
+
+// Migrator moves data from Primary to Secondary backend by writing
+// both. Divergence of secondary, errors or replies differ from
+// primary, is counted and logged.
+type Migrator struct {
+	Primary   Backend
+	Secondary Backend
+
+	phase atomic.Value // string
+	async bool
+	queue chan *migrateWrite
+	quit  chan struct{}
+
+	// atomic
+	writes     int64 // writes sent to secondary
+	errors     int64 // secondary failed
+	mismatches int64 // secondary replied differently
+	dropped    int64 // async writes dropped when queue is full
+	fallbacks  int64 // secondary reads fell back to primary
+}
+
+func NewMigrator(primary, secondary Backend, phase string, async bool) *Migrator {
+	m := &Migrator{
+		Primary:   primary,
+		Secondary: secondary,
+		async:     async,
+		queue:     make(chan *migrateWrite, migrateQueueSize),
+		quit:      make(chan struct{}),
+	}
+	m.SetPhase(phase)
+	for i := 0; i < migrateWorkers; i++ {
+		go m.worker()
+	}
+	return m
+}
+
+func (m *Migrator) Phase() string {
+	return m.phase.Load().(string)
+}
+
+func (m *Migrator) SetPhase(phase string) {
+	m.phase.Store(phase)
+	log.Warning("migrate phase changed to ", phase)
+}
+
+// SetMigratePhase changes phase of migrator in use, config keeps a copy
+// for PROXY CONFIG RELOAD to compare with
+func (ps *ProxyServer) SetMigratePhase(phase string) {
+	ps.confLock.Lock()
+	defer ps.confLock.Unlock()
+	ps.Migrate.SetPhase(phase)
+	ps.Conf.MigratePhase = phase
+}
+
+// Stats returns counters of secondary, they only grow
+func (m *Migrator) Stats() map[string]int64 {
+	return map[string]int64{
+		"writes":     atomic.LoadInt64(&m.writes),
+		"errors":     atomic.LoadInt64(&m.errors),
+		"mismatches": atomic.LoadInt64(&m.mismatches),
+		"dropped":    atomic.LoadInt64(&m.dropped),
+		"fallbacks":  atomic.LoadInt64(&m.fallbacks),
+	}
+}
+
+func (m *Migrator) Dispatch(req *redis.Request) redis.Cmder {
+	phase := m.Phase()
+	if phase == MigrateOff {
+		return m.Primary.Dispatch(req)
+	}
+
+	name := req.Name()
+	if phase == MigrateReadSecondary && redis.IsReadOnly(name) {
+		resp := m.Secondary.Dispatch(req)
+		err := resp.Err()
+		if err == nil {
+			return resp
+		}
+		if err != redis.Nil {
+			atomic.AddInt64(&m.errors, 1)
+			log.Warningf("migrate: secondary %s %v failed: %s", name, req.Args(), err)
+		}
+		atomic.AddInt64(&m.fallbacks, 1)
+		return m.Primary.Dispatch(req)
+	}
+
+	resp := m.Primary.Dispatch(req)
+	if !redis.IsWrite(name) {
+		return resp
+	}
+	// don't make secondary have what primary doesn't
+	if err := resp.Err(); err != nil && err != redis.Nil {
+		return resp
+	}
+
+	w := &migrateWrite{req: req, reply: resp.Reply()}
+	if !m.async {
+		m.write(w)
+		return resp
+	}
+	select {
+	case m.queue <- w:
+	default:
+		atomic.AddInt64(&m.dropped, 1)
+	}
+	return resp
+}
+
+func (m *Migrator) write(w *migrateWrite) {
+	atomic.AddInt64(&m.writes, 1)
+	resp := m.Secondary.Dispatch(w.req)
+	if err := resp.Err(); err != nil && err != redis.Nil {
+		atomic.AddInt64(&m.errors, 1)
+		log.Warningf("migrate: secondary %s %v failed: %s", w.req.Name(), w.req.Args(), err)
+		return
+	}
+	if reply := resp.Reply(); !bytes.Equal(reply, w.reply) {
+		atomic.AddInt64(&m.mismatches, 1)
+		log.Warningf("migrate: secondary %s %v replied %q, primary %q",
+			w.req.Name(), w.req.Args(), reply, w.reply)
+	}
+}
+
+func (m *Migrator) worker() {
+	for {
+		select {
+		case w := <-m.queue:
+			m.write(w)
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// Close stops async writes and closes secondary, writes still
+// queued are dropped
+func (m *Migrator) Close() error {
+	close(m.quit)
+	return m.Secondary.Close()
+}
@@ -0,0 +1,137 @@
+package smartproxy
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dongzerun/smartproxy/redis"
+	log "github.com/ngaut/logging"
+)
+
+const mirrorWorkers = 4
+
+// mirrorReq is a copy of request waiting to be sent to shadow, with
+// reply client got to compare
+type mirrorReq struct {
+	req   *redis.Request
+	reply []byte
+}
+
+// Mirror copies sampled requests to a shadow cluster in background,
+// client never waits for shadow. Copies are dropped if queue is full.
+type Mirror struct {
+	Shadow Backend
+
+	percent  int32 // atomic, 0 ~ 100
+	readOnly bool
+	compare  bool
+
+	queue chan *mirrorReq
+	quit  chan struct{}
+
+	// atomic
+	sent    int64
+	dropped int64
+	errors  int64
+
+	lock       sync.Mutex
+	mismatches map[string]int64 // by command
+}
+
+func NewMirror(shadow Backend, percent int, readOnly, compare bool, queue int) *Mirror {
+	m := &Mirror{
+		Shadow:     shadow,
+		readOnly:   readOnly,
+		compare:    compare,
+		queue:      make(chan *mirrorReq, queue),
+		quit:       make(chan struct{}),
+		mismatches: make(map[string]int64),
+	}
+	m.SetPercent(percent)
+	for i := 0; i < mirrorWorkers; i++ {
+		go m.worker()
+	}
+	return m
+}
+
+func (m *Mirror) Percent() int {
+	return int(atomic.LoadInt32(&m.percent))
+}
+
+func (m *Mirror) SetPercent(percent int) {
+	atomic.StoreInt32(&m.percent, int32(percent))
+}
+
+// Stats returns counters, mismatches are keyed by mismatch.<CMD>
+func (m *Mirror) Stats() map[string]int64 {
+	stats := map[string]int64{
+		"sent":    atomic.LoadInt64(&m.sent),
+		"dropped": atomic.LoadInt64(&m.dropped),
+		"errors":  atomic.LoadInt64(&m.errors),
+	}
+	m.lock.Lock()
+	for name, n := range m.mismatches {
+		stats["mismatch."+name] = n
+	}
+	m.lock.Unlock()
+	return stats
+}
+
+// Copy samples req which has been answered with reply, it never blocks
+func (m *Mirror) Copy(req *redis.Request, reply []byte) {
+	percent := m.Percent()
+	if percent <= 0 || (percent < 100 && rand.Intn(100) >= percent) {
+		return
+	}
+	if m.readOnly && !redis.IsReadOnly(req.Name()) {
+		return
+	}
+
+	r := &mirrorReq{req: req}
+	if m.compare {
+		r.reply = reply
+	}
+	select {
+	case m.queue <- r:
+	default:
+		atomic.AddInt64(&m.dropped, 1)
+	}
+}
+
+func (m *Mirror) send(r *mirrorReq) {
+	atomic.AddInt64(&m.sent, 1)
+	resp := m.Shadow.Dispatch(r.req)
+	if err := resp.Err(); err != nil && err != redis.Nil {
+		atomic.AddInt64(&m.errors, 1)
+		return
+	}
+	if !m.compare {
+		return
+	}
+	if reply := resp.Reply(); !bytes.Equal(reply, r.reply) {
+		name := r.req.Name()
+		m.lock.Lock()
+		m.mismatches[name]++
+		m.lock.Unlock()
+		log.Infof("mirror: shadow %s %v replied %q, want %q", name, r.req.Args(), reply, r.reply)
+	}
+}
+
+func (m *Mirror) worker() {
+	for {
+		select {
+		case r := <-m.queue:
+			m.send(r)
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// Close stops copying and closes shadow, queued copies are dropped
+func (m *Mirror) Close() error {
+	close(m.quit)
+	return m.Shadow.Close()
+}
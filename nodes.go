@@ -0,0 +1,62 @@
+package smartproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	log "github.com/ngaut/logging"
+)
+
+var NodesNotSupported = errors.New("nodes only supported by cluster backend")
+
+func (ps *ProxyServer) clusterBackend() (*clusterBackend, error) {
+	b, ok := ps.Backend.(*clusterBackend)
+	if !ok {
+		return nil, NodesNotSupported
+	}
+	return b, nil
+}
+
+// AddNodes adds seed nodes and saves them to config file, seeds are
+// kept until RemoveNodes or RefreshNodes even if not in CLUSTER SLOTS
+func (ps *ProxyServer) AddNodes(addrs []string) error {
+	b, err := ps.clusterBackend()
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid node %s", addr)
+		}
+	}
+	b.AddAddrs(addrs)
+	return ps.saveNodes()
+}
+
+// RemoveNodes removes seed nodes not owning slots and saves config file,
+// the last seed can not be removed
+func (ps *ProxyServer) RemoveNodes(addrs []string) error {
+	b, err := ps.clusterBackend()
+	if err != nil {
+		return err
+	}
+	if err := b.RemoveAddrs(addrs); err != nil {
+		return err
+	}
+	return ps.saveNodes()
+}
+
+// RefreshNodes reloads slots now, seeds not in slots are dropped, and
+// saves config file
+func (ps *ProxyServer) RefreshNodes() error {
+	b, err := ps.clusterBackend()
+	if err != nil {
+		return err
+	}
+	if err := b.Refresh(); err != nil {
+		log.Warning("refresh nodes failed ", err)
+		return err
+	}
+	return ps.saveNodes()
+}
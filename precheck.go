@@ -4,9 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/dongzerun/smartproxy/redis"
-	log "github.com/ngaut/logging"
 	"strings"
-	"time"
 )
 
 var (
@@ -19,8 +17,7 @@ var (
 	CommandNotSupported  = errors.New("command not supported")
 	UnknowProxyOpType    = errors.New("Unknow args type for proxy command")
 	BlackTimeUnavaliable = errors.New("black time unavaliable")
-
-	BlackKeyLists = make(map[string]*BlackKey)
+	ProxyDraining        = errors.New("proxy is draining")
 )
 
 const (
@@ -28,19 +25,11 @@ const (
 	RI_MaxCount // -1 for undefined
 )
 
-type BlackKey struct {
-	Name     string
-	Startup  time.Time
-	Deadline time.Time
-}
-
-func init() {
-	go ExpireBlackLists()
-}
-
 var reqrules = map[string][]interface{}{
 	// proxy special command
-	"PROXY": []interface{}{2, 5},
+	"PROXY": []interface{}{2, -1},
+	// cluster command, answered by proxy itself
+	"CLUSTER": []interface{}{2, 3},
 	// key
 	"DEL":       []interface{}{2, 2001},
 	"TYPE":      []interface{}{2, 2},
@@ -56,6 +45,7 @@ var reqrules = map[string][]interface{}{
 	"RENAMENX":  []interface{}{3, 3},
 	"DUMP":      []interface{}{2, 2},
 	"RESTORE":   []interface{}{4, 4},
+	"SORT":      []interface{}{2, -1},
 	// bit
 
 	"SETBIT":   []interface{}{4, 4},
@@ -150,6 +140,7 @@ var reqrules = map[string][]interface{}{
 
 var specList = map[string]bool{
 	"PROXY":       true,
+	"CLUSTER":     true,
 	"RENAME":      true,
 	"RENAMENX":    true,
 	"MGET":        true,
@@ -166,6 +157,22 @@ var specList = map[string]bool{
 	"ZINTERSTORE": true,
 }
 
+// stubList are special commands not implemented yet, special.go replies
+// +OK without running them
+var stubList = map[string]bool{
+	"RENAME":      true,
+	"RENAMENX":    true,
+	"MSETNX":      true,
+	"RPOPLPUSH":   true,
+	"SDIFF":       true,
+	"SDIFFSTORE":  true,
+	"SINTER":      true,
+	"SINTERSTORE": true,
+	"SMOVE":       true,
+	"ZUNIONSTORE": true,
+	"ZINTERSTORE": true,
+}
+
 var blackList = map[string]bool{
 	"BGREWRITEAOF": true,
 	"BGSAVE":       true,
@@ -221,14 +228,15 @@ var blackList = map[string]bool{
 	"ZINTERSTORE":  true,
 }
 
-func verifyCommand(req *redis.Request) error {
+// verifyCommand checks args of req, allowed commands skip blackList
+func verifyCommand(req *redis.Request, allowed bool) error {
 	if req == nil || req.Len() == 0 {
 		return BadCommandError
 	}
 
 	name := req.Name()
 
-	if _, ok := blackList[name]; ok {
+	if _, ok := blackList[name]; ok && !allowed {
 		return CommandForbidden
 	}
 
@@ -255,13 +263,17 @@ func verifyCommand(req *redis.Request) error {
 }
 
 // buf, shouldClose, handled, err
-func preCheckCommand(req *redis.Request) ([]byte, bool, bool, error) {
+func preCheckCommand(req *redis.Request, cmds *Commands) ([]byte, bool, bool, error) {
 	var reply []byte
 	shouldClose := false
 
 	if req.Len() == 0 {
 		return reply, false, true, BadCommandError
 	}
+	renamed, err := cmds.resolve(req)
+	if err != nil {
+		return reply, false, true, err
+	}
 	cmd := req.Name()
 	switch cmd {
 	case "PING":
@@ -288,18 +300,11 @@ func preCheckCommand(req *redis.Request) ([]byte, bool, bool, error) {
 		return reply, shouldClose, true, nil
 	}
 
-	if err := verifyCommand(req); err != nil {
+	// commands renamed are hidden, not forbidden
+	if err := verifyCommand(req, renamed || cmds.allowed(cmd)); err != nil {
 		return nil, shouldClose, true, err
 	}
 
-	if len(req.Args()) >= 1 {
-		if _, ok := BlackKeyLists[req.Args()[0]]; ok {
-			// key blacked
-			reply = []byte("-key already be blacked \r\n")
-			return reply, shouldClose, true, nil
-		}
-	}
-
 	return reply, shouldClose, false, nil
 }
 
@@ -307,18 +312,3 @@ func isSpecCommand(cmd string) bool {
 	_, exists := specList[strings.ToUpper(cmd)]
 	return exists
 }
-
-func ExpireBlackLists() {
-	ticker := time.NewTicker(30 * time.Second)
-	for {
-		select {
-		case <-ticker.C:
-			for k, b := range BlackKeyLists {
-				if b.Deadline.Before(time.Now()) {
-					log.Warningf("Black key: %s last: %s deadline: %s reached, will be expired...", b.Name, b.Deadline.Sub(b.Startup), b.Deadline.String())
-					delete(BlackKeyLists, k)
-				}
-			}
-		}
-	}
-}
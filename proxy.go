@@ -2,12 +2,13 @@ package smartproxy
 
 import (
 	"github.com/dongzerun/smartproxy/redis"
+	"github.com/dongzerun/smartproxy/statsd"
 	"github.com/dongzerun/smartproxy/util"
 	"net"
-	"reflect"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/ngaut/logging"
@@ -20,12 +21,46 @@ type ProxyServer struct {
 	//proxy config struct
 	Conf *ProxyConfig
 
-	//redis cluster client
-	Backend *redis.ClusterClient
+	//redis cluster, sentinel, ring or single redis
+	Backend Backend
+	//dual write to secondary backend, nil if not configured
+	Migrate *Migrator
+	//copy sampled requests to shadow cluster, nil if not configured
+	Mirror *Mirror
+	//hot key read cache, nil if not enabled
+	Cache *Cache
+	//hot key and big key detection, nil if not enabled
+	KeyStats *KeyStats
+	//identical reads share backend call, nil if not enabled
+	Coalescer *Coalescer
+	//recent cluster topology events
+	Events *Events
+	//collectors of /metrics, nil if admin api disabled
+	Metrics *Metrics
+	//shared by all statsd loops, sends nothing if statsd not set
+	Statsd *statsd.Client
+	//publishes proxy, nil if not configured
+	Registry Registry
+	//rejects commands of blacked keys
+	Blacklist *Blacklist
 
-	Lock        sync.Mutex
-	SessMgr     map[string]*Session
-	RedisMethod map[string]reflect.Value
+	Lock    sync.Mutex
+	SessMgr map[string]*Session
+
+	//*Commands of [commands], replaced on reload
+	commands atomic.Value
+
+	//serializes config reload and save
+	confLock sync.Mutex
+
+	//set once SIGTERM received, atomic
+	draining int32
+	//set while new process starts, and after it is ready, atomic
+	upgrading int32
+	//admin api listener, passed to new process by Upgrade
+	adminListen net.Listener
+	//found by registry
+	proxies []ProxyNode
 
 	Quit    chan bool
 	Wg      util.WaitGroupWrapper
@@ -39,47 +74,104 @@ type ProxyServer struct {
 }
 
 func NewProxyServer(c *ProxyConfig) *ProxyServer {
-	opt := &redis.ClusterOptions{
-		Addrs:    c.Nodes,
-		PoolSize: c.PoolSizePerNode,
+	ps := &ProxyServer{
+		Conf:     c,
+		Quit:     make(chan bool, 1),
+		SessMgr:  make(map[string]*Session, 1024),
+		Startup:  time.Now(),
+		TimeChan: make(chan int64, 1024),
+		QpsChan:  make(chan int64, 1024),
 	}
 
-	ps := &ProxyServer{
-		Conf:        c,
-		Quit:        make(chan bool, 1),
-		Backend:     redis.NewClusterClient(opt),
-		SessMgr:     make(map[string]*Session, 1024),
-		RedisMethod: make(map[string]reflect.Value, 120),
-		Startup:     time.Now(),
-		TimeChan:    make(chan int64, 1024),
-		QpsChan:     make(chan int64, 1024),
+	backend, err := NewBackend(c)
+	if err != nil {
+		log.Fatal(err)
 	}
+	ps.Backend = backend
 
-	go ps.ExpireClient()
-	return ps
-}
+	if c.AdminPort != "" {
+		ps.Metrics = NewMetrics()
+		redis.SetCommandHook(ps.Metrics.ObserveNode)
+	}
 
-func (ps *ProxyServer) Dispatch(req *redis.Request) redis.Cmder {
+	ps.Statsd = statsd.NewClient(c.Statsd, c.StatsdPrefix, c.StatsdTags...)
+
+	blacklist, err := NewBlacklist(c.BlackFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	blacklist.SetConfig(c.BlackKeys)
+	ps.Blacklist = blacklist
 
-	name := req.Name()
+	if err := ps.setCommands(c); err != nil {
+		log.Fatal(err)
+	}
 
-	method, ok := ps.RedisMethod[name]
+	registry, err := NewRegistry(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ps.Registry = registry
 
-	if !ok {
-		method = reflect.ValueOf(ps.Backend).MethodByName("On" + name)
-		ps.RedisMethod[name] = method
+	ps.Events = NewEvents(eventsSize)
+	if b, ok := backend.(*clusterBackend); ok {
+		b.OnEvent(ps.Events.Add)
 	}
 
-	if method.IsValid() {
-		in := []reflect.Value{reflect.ValueOf(req)}
-		callResult := method.Call(in)
-		if callResult[0].Interface() != nil {
-			return callResult[0].Interface().(redis.Cmder)
+	if c.Secondary != nil {
+		secondary, err := NewBackend(c.Secondary)
+		if err != nil {
+			log.Fatal(err)
 		}
-	} else {
-		return ps.Backend.OnReflectUnvalid(req)
+		ps.Migrate = NewMigrator(backend, secondary, c.MigratePhase, c.MigrateAsync)
+	}
+
+	if len(c.MirrorNodes) > 0 {
+		shadow := newClusterBackend(c.MirrorOptions())
+		ps.Mirror = NewMirror(shadow, c.MirrorPercent, c.MirrorReadOnly, c.MirrorCompare, c.MirrorQueue)
+	}
+
+	if c.Cache {
+		ttl := time.Duration(c.CacheTTL) * time.Millisecond
+		ps.Cache = NewCache(ttl, c.CacheMemory<<20, c.CachePatterns, c.CacheHotQps)
+		// keys may live on other nodes now
+		ps.Backend.Watch(ps.Cache.Clear)
+	}
+
+	if c.KeyStats {
+		ps.KeyStats = NewKeyStats(c.KeyStatsPercent, c.KeyStatsTopK, c.HotKeyQps, c.BigKeySize)
+	}
+
+	if c.Coalesce {
+		ps.Coalescer = NewCoalescer(c.CoalesceCommands, c.CoalesceWaiters)
+	}
+
+	go ps.ExpireClient()
+	return ps
+}
+
+func (ps *ProxyServer) Dispatch(req *redis.Request) redis.Cmder {
+	if ps.Migrate != nil {
+		return ps.Migrate.Dispatch(req)
+	}
+	return ps.Backend.Dispatch(req)
+}
+
+// Sessions returns a copy of sessions by client addr
+func (ps *ProxyServer) Sessions() map[string]*Session {
+	ps.Lock.Lock()
+	defer ps.Lock.Unlock()
+	sessions := make(map[string]*Session, len(ps.SessMgr))
+	for addr, s := range ps.SessMgr {
+		sessions[addr] = s
 	}
-	return ps.Backend.OnUnDenfined(req)
+	return sessions
+}
+
+func (ps *ProxyServer) SessionCount() int {
+	ps.Lock.Lock()
+	defer ps.Lock.Unlock()
+	return len(ps.SessMgr)
 }
 
 func (ps *ProxyServer) ExpireClient() {
@@ -90,9 +182,10 @@ func (ps *ProxyServer) ExpireClient() {
 			goto quit
 		case <-ticker.C:
 			now := time.Now().Unix()
-			for addr, s := range ps.SessMgr {
-				log.Infof("%s session idle time %d", addr, now-s.LastAccess/1e6)
-				if now-s.LastAccess/1e6 > ps.Conf.IdleTime {
+			for addr, s := range ps.Sessions() {
+				last := atomic.LoadInt64(&s.LastAccess)
+				log.Infof("%s session idle time %d", addr, now-last/1e6)
+				if now-last/1e6 > ps.Conf.IdleTime {
 					log.Warningf("session %s time out, we close forcely", s.Conn.RemoteAddr().String())
 					ps.Lock.Lock()
 					delete(ps.SessMgr, addr)
@@ -108,21 +201,94 @@ quit:
 }
 
 func (ps *ProxyServer) Close() {
+	// listener is closed by Drain already
+	if !ps.Draining() {
+		err := ps.Listen.Close()
+		if err != nil {
+			log.Warning("Close Listener err ", err)
+		}
+		log.Info("Proxy Server Close Listener ")
+	}
+	close(ps.Quit)
+	ps.Wg.Wait()
+
+	if ps.Migrate != nil {
+		ps.Migrate.Close()
+	}
+	if ps.Mirror != nil {
+		ps.Mirror.Close()
+	}
+	if err := ps.Backend.Close(); err != nil {
+		log.Warning("Close Backend err ", err)
+	}
+	ps.Statsd.Close()
+	log.Warning("Proxy Server Close ....")
+}
+
+// Draining reports whether proxy is leaving, PING and /health fail then
+func (ps *ProxyServer) Draining() bool {
+	return atomic.LoadInt32(&ps.draining) == 1
+}
 
+// Drain stops accepting connections, idle sessions are closed at once
+// and busy ones after their current request. Sessions left after
+// GraceTime are closed.
+func (ps *ProxyServer) Drain() {
+	if !atomic.CompareAndSwapInt32(&ps.draining, 0, 1) {
+		return
+	}
 	err := ps.Listen.Close()
 	if err != nil {
 		log.Warning("Close Listener err ", err)
 	}
-	log.Info("Proxy Server Close Listener ")
-	close(ps.Quit)
-	ps.Wg.Wait()
-	log.Warning("Proxy Server Close ....")
+	// clients stop finding us before sessions are closed
+	if ps.Registry != nil {
+		if err := ps.Registry.Deregister(); err != nil {
+			log.Warning("deregister proxy failed ", err)
+		}
+	}
+
+	grace := time.Duration(ps.Conf.GraceTime) * time.Second
+	log.Warningf("Proxy Server draining %d sessions in %s", ps.SessionCount(), grace)
+	// busy sessions close themselves after reply
+	for _, s := range ps.Sessions() {
+		s.closeIdle()
+	}
+
+	deadline := time.Now().Add(grace)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for ps.SessionCount() > 0 && time.Now().Before(deadline) {
+		<-ticker.C
+	}
+
+	sessions := ps.Sessions()
+	if len(sessions) == 0 {
+		log.Warning("Proxy Server drained")
+		return
+	}
+	log.Warningf("grace time reached, close %d sessions", len(sessions))
+	for _, s := range sessions {
+		s.Close()
+	}
 }
 
 func (ps *ProxyServer) Init() {
 	log.Info("Proxy Server Init ....")
 
-	l, err := net.Listen("tcp4", "0.0.0.0:"+ps.Conf.Port)
+	// old process passes its listener when upgrading
+	l, err := inheritedListener(listenerFdEnv)
+	if err != nil {
+		log.Fatal("Proxy Server inherit listener failed ", err)
+	}
+	if l != nil {
+		log.Info("Proxy Server inherit listener ", l.Addr())
+		ps.Listen = l
+		notifyReady()
+		return
+	}
+
+	l, err = net.Listen("tcp4", "0.0.0.0:"+ps.Conf.Port)
 	// net.Listen(net, laddr)
 	if err != nil {
 		log.Fatalf("Proxy Server Listen on port : %s failed ", ps.Conf.Port)
@@ -1,10 +1,13 @@
 package smartproxy
 
 import (
+	"errors"
 	"fmt"
 	"github.com/dongzerun/smartproxy/redis"
+	"os"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	log "github.com/ngaut/logging"
@@ -28,14 +31,66 @@ func (s *Session) PROXY(req *redis.Request) {
 			return
 		}
 		s.proxyBlack(req)
+	case "upgrade":
+		// proxy upgrade
+		if len(req.Args()) != 1 {
+			err := fmt.Sprintf("-%s\r\n", WrongArgumentCount)
+			s.write2client([]byte(err))
+			return
+		}
+		s.proxyUpgrade()
 	case "config":
 		// proxy config set name value
-		if len(req.Args()) < 3 || len(req.Args()) > 4 {
+		// proxy config reload
+		if len(req.Args()) < 2 || len(req.Args()) > 4 {
 			err := fmt.Sprintf("-%s\r\n", WrongArgumentCount)
 			s.write2client([]byte(err))
 			return
 		}
 		s.proxyConf(req)
+	case "migrate":
+		// proxy migrate phase [off|dualwrite|readsecondary]
+		// proxy migrate stats
+		if len(req.Args()) < 2 || len(req.Args()) > 3 {
+			err := fmt.Sprintf("-%s\r\n", WrongArgumentCount)
+			s.write2client([]byte(err))
+			return
+		}
+		s.proxyMigrate(req)
+	case "nodes":
+		// proxy nodes list|refresh
+		// proxy nodes add|remove addr [addr ...]
+		if len(req.Args()) < 2 {
+			err := fmt.Sprintf("-%s\r\n", WrongArgumentCount)
+			s.write2client([]byte(err))
+			return
+		}
+		s.proxyNodes(req)
+	case "commands":
+		// proxy commands list|reload
+		if len(req.Args()) != 2 {
+			err := fmt.Sprintf("-%s\r\n", WrongArgumentCount)
+			s.write2client([]byte(err))
+			return
+		}
+		s.proxyCommands(req)
+	case "events":
+		// proxy events [n]
+		if len(req.Args()) > 2 {
+			err := fmt.Sprintf("-%s\r\n", WrongArgumentCount)
+			s.write2client([]byte(err))
+			return
+		}
+		s.proxyEvents(req)
+	case "hotkeys", "bigkeys":
+		// proxy hotkeys [n]
+		// proxy bigkeys [n]
+		if len(req.Args()) > 2 {
+			err := fmt.Sprintf("-%s\r\n", WrongArgumentCount)
+			s.write2client([]byte(err))
+			return
+		}
+		s.proxyKeyStats(req)
 	default:
 		log.Warning("Unknow proxy op type: ", req.Args())
 		err := fmt.Sprintf("-%s\r\n", UnknowProxyOpType)
@@ -45,16 +100,30 @@ func (s *Session) PROXY(req *redis.Request) {
 
 }
 
-//loglevel  idletime  mulparallel  statsd  slaveok
+//loglevel  idletime  mulparallel  statsd  slaveok  and backend options
 func (s *Session) proxyConf(req *redis.Request) {
 	// proxy config set loglevel info
 	// proxy config set idletime 200
 	// proxy config set slaveok 1|0
 	// proxy config set mulparallel 30
 	// proxy config get statsd
+	// proxy config reload
 	args := req.Args()
-	// config get|set
+	// config get|set|reload
 	switch strings.ToLower(args[1]) {
+	case "reload":
+		if len(req.Args()) != 2 {
+			err := fmt.Sprintf("-%s\r\n", WrongArgumentCount)
+			s.write2client([]byte(err))
+			return
+		}
+		changes, err := s.Proxy.ReloadConfig()
+		if err != nil {
+			s.write2client([]byte(fmt.Sprintf("-%s\r\n", err)))
+			return
+		}
+		s.write2client(redis.FormatStringSlice(changes))
+		return
 	case "get":
 		if len(req.Args()) != 3 {
 			err := fmt.Sprintf("-%s\r\n", WrongArgumentCount)
@@ -82,110 +151,40 @@ func (s *Session) proxyConf(req *redis.Request) {
 	}
 }
 
+// proxyUpgrade replies pid of new process, this one drains as on SIGTERM
+func (s *Session) proxyUpgrade() {
+	pid, err := s.Proxy.Upgrade()
+	if err != nil {
+		s.write2client([]byte(fmt.Sprintf("-%s\r\n", err)))
+		return
+	}
+	s.write2client(redis.FormatInt(int64(pid)))
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+}
+
 // setbyname will set name by value
 // return old value of name
 func (s *Session) proxyConfigSetByName(name string, value string) []byte {
-	var reply []byte
-
-	switch name {
-	case "loglevel":
-		v := strings.ToLower(value)
-		if v != "info" || v != "warning" || v != "debug" {
-			reply = []byte("-loglevel must be info warning or debug\r\n")
-			return reply
-		}
-		reply = s.proxyConfigGetByName(name)
-		log.SetLevelByString(v)
-	case "idletime":
-		v, err := strconv.Atoi(value)
-		if err != nil {
-			reply = []byte("-unavailable idletime\r\n")
-			return reply
-		}
-		if v < MinIdleTime || v > MaxIdleTime {
-			reply = []byte("-unavailable idletime, must between 10 ~ 300\r\n")
-			return reply
-		}
-		reply = s.proxyConfigGetByName("idletime")
-		s.Proxy.Conf.IdleTime = int64(v)
-	case "slaveok":
-		v, err := strconv.Atoi(value)
-		if err != nil {
-			reply = []byte("-unavailable slaveok,must 0 or 1\r\n")
-			return reply
-		}
-		if v != 0 || v != 1 {
-			reply = []byte("-unavailable slaveok,must 0 or 1\r\n")
-			return reply
-		}
-		reply = s.proxyConfigGetByName("slaveok")
-		if v == 1 {
-			s.Proxy.Conf.SlaveOk = true
-		} else {
-			s.Proxy.Conf.SlaveOk = false
-		}
-	case "mulparallel":
-		v, err := strconv.Atoi(value)
-		if err != nil {
-			reply = []byte("-unavailable mulparallel\r\n")
-			return reply
-		}
-		if v < MinMulOpParallel || v > MaxMulOpParallel {
-			reply = []byte("-unavailable mulparallel, must between 5 ~ 100\r\n")
-			return reply
-		}
-		reply = s.proxyConfigGetByName("mulparallel")
-		s.Proxy.Conf.MulOpParallel = v
-	case "statsd":
-		reply = s.proxyConfigGetByName("statsd")
-		s.Proxy.Conf.Statsd = value
-	case "maxconn":
-		v, err := strconv.Atoi(value)
-		if err != nil {
-			reply = []byte("-unavailable maxconn\r\n")
-			return reply
-		}
-		if v < MinMaxConn || v > MaxMaxConn {
-			reply = []byte("-unavailable maxconn, must between 100 ~ 60000\r\n")
-			return reply
-		}
-		reply = s.proxyConfigGetByName("maxconn")
-		s.Proxy.Conf.MaxConn = int64(v)
-	default:
-		reply = []byte("-wrong proxy config name\r\n")
+	old, err := s.Proxy.ConfigSet(name, value)
+	if err != nil {
+		return []byte(fmt.Sprintf("-%s\r\n", err))
 	}
-	return reply
+	return formatConfigValue(old)
 }
 
 func (s *Session) proxyConfigGetByName(name string) []byte {
-	var reply []byte
-	switch name {
-	case "loglevel":
-		t := log.GetLogLevel()
-		s, _ := log.LogTypeToString(log.LogType(t))
-		reply = redis.FormatString(s)
-	case "idletime":
-		time := s.Proxy.Conf.IdleTime
-		reply = redis.FormatInt(int64(time))
-	case "maxconn":
-		time := s.Proxy.Conf.MaxConn
-		reply = redis.FormatInt(int64(time))
-	case "slaveok":
-		if s.Proxy.Conf.SlaveOk {
-			reply = redis.FormatInt(1)
-		} else {
-			reply = redis.FormatInt(0)
-		}
-	case "mulparallel":
-		parallel := s.Proxy.Conf.MulOpParallel
-		reply = redis.FormatInt(int64(parallel))
-	case "statsd":
-		statsd := s.Proxy.Conf.Statsd
-		reply = redis.FormatString(statsd)
-	default:
-		reply = []byte("-wrong proxy config name\r\n")
+	v, err := s.Proxy.ConfigGet(name)
+	if err != nil {
+		return []byte(fmt.Sprintf("-%s\r\n", err))
 	}
-	return reply
+	return formatConfigValue(v)
+}
+
+func formatConfigValue(v interface{}) []byte {
+	if n, ok := v.(int64); ok {
+		return redis.FormatInt(n)
+	}
+	return redis.FormatString(v.(string))
 }
 
 func (s *Session) proxyInfo(req *redis.Request) {
@@ -196,20 +195,197 @@ func (s *Session) proxyInfo(req *redis.Request) {
 	zk := fmt.Sprintf("zk:%s", s.Proxy.Conf.Zk)
 	zkpath := fmt.Sprintf("zkpath:%s", s.Proxy.Conf.ZkPath)
 	qps := fmt.Sprintf("qps:%d", s.Proxy.LastQPS)
-	conns := fmt.Sprintf("conns:%d", len(s.Proxy.SessMgr))
-	nodes := "nodes:"
-	r := []string{name, id, port, statsd, zk, zkpath, qps, conns, nodes}
-	for _, h := range s.Proxy.Conf.Nodes {
-		hs := fmt.Sprintf("%s", h)
-		r = append(r, hs)
+	conns := fmt.Sprintf("conns:%d", s.Proxy.SessionCount())
+	backend := fmt.Sprintf("backend:%s", s.Proxy.Conf.BackendType)
+	r := []string{name, id, port, statsd, zk, zkpath, qps, conns, backend}
+	r = append(r, s.Proxy.Backend.Info()...)
+	if c := s.Proxy.Cache; c != nil {
+		stats := c.Stats()
+		r = append(r, "cache:")
+		for _, name := range []string{"hits", "misses", "evictions", "entries", "bytes"} {
+			r = append(r, fmt.Sprintf("%s:%d", name, stats[name]))
+		}
+	}
+	if c := s.Proxy.Coalescer; c != nil {
+		stats := c.Stats()
+		r = append(r, "coalesce:")
+		for _, name := range []string{"calls", "coalesced", "overflows"} {
+			r = append(r, fmt.Sprintf("%s:%d", name, stats[name]))
+		}
+	}
+	if s.Proxy.Registry != nil {
+		r = append(r, fmt.Sprintf("registry:%s", s.Proxy.Conf.RegistryType))
+		for _, p := range s.Proxy.Proxies() {
+			r = append(r, fmt.Sprintf("%s %s:%s", p.Id, p.Addr, p.Port))
+		}
+	}
+	if m := s.Proxy.Mirror; m != nil {
+		r = append(r, fmt.Sprintf("mirror:%d%%", m.Percent()))
+		r = append(r, s.Proxy.Conf.MirrorNodes...)
+	}
+	if m := s.Proxy.Migrate; m != nil {
+		r = append(r, fmt.Sprintf("migrate:%s", m.Phase()))
+		r = append(r, fmt.Sprintf("secondary:%s", s.Proxy.Conf.Secondary.BackendType))
+		r = append(r, m.Secondary.Info()...)
 	}
 	reply := redis.FormatStringSlice(r)
 	s.write2client(reply)
 }
 
+func (s *Session) proxyMigrate(req *redis.Request) {
+	m := s.Proxy.Migrate
+	if m == nil {
+		s.write2client([]byte("-migrate not configured\r\n"))
+		return
+	}
+
+	args := req.Args()
+	switch strings.ToLower(args[1]) {
+	case "phase":
+		if len(args) == 2 {
+			s.write2client(redis.FormatString(m.Phase()))
+			return
+		}
+		phase := strings.ToLower(args[2])
+		if !isMigratePhase(phase) {
+			s.write2client([]byte("-unavailable phase, must off dualwrite or readsecondary\r\n"))
+			return
+		}
+		s.Proxy.SetMigratePhase(phase)
+		s.write2client(OK_BYTES)
+	case "stats":
+		if len(args) != 2 {
+			err := fmt.Sprintf("-%s\r\n", WrongArgumentCount)
+			s.write2client([]byte(err))
+			return
+		}
+		stats := m.Stats()
+		r := []string{fmt.Sprintf("phase:%s", m.Phase())}
+		for _, name := range []string{"writes", "errors", "mismatches", "dropped", "fallbacks"} {
+			r = append(r, fmt.Sprintf("%s:%d", name, stats[name]))
+		}
+		s.write2client(redis.FormatStringSlice(r))
+	default:
+		err := fmt.Sprintf("-%s\r\n", UnknowProxyOpType)
+		s.write2client([]byte(err))
+	}
+}
+
+func (s *Session) proxyNodes(req *redis.Request) {
+	args := req.Args()
+	op := strings.ToLower(args[1])
+	var err error
+	switch op {
+	case "list":
+		if len(args) != 2 {
+			err = WrongArgumentCount
+			break
+		}
+		s.write2client(redis.FormatStringSlice(s.Proxy.Backend.Nodes()))
+		return
+	case "add", "remove":
+		if len(args) < 3 {
+			err = WrongArgumentCount
+			break
+		}
+		log.Warningf("audit: %s PROXY NODES %s %s", s.Conn.RemoteAddr(), op, strings.Join(args[2:], " "))
+		if op == "add" {
+			err = s.Proxy.AddNodes(args[2:])
+		} else {
+			err = s.Proxy.RemoveNodes(args[2:])
+		}
+	case "refresh":
+		if len(args) != 2 {
+			err = WrongArgumentCount
+			break
+		}
+		log.Warningf("audit: %s PROXY NODES refresh", s.Conn.RemoteAddr())
+		err = s.Proxy.RefreshNodes()
+	default:
+		err = errors.New("wrong proxy nodes op type")
+	}
+	if err != nil {
+		s.write2client([]byte(fmt.Sprintf("-%s\r\n", err)))
+		return
+	}
+	s.write2client(OK_BYTES)
+}
+
+func (s *Session) proxyCommands(req *redis.Request) {
+	switch strings.ToLower(req.Args()[1]) {
+	case "list":
+		s.write2client(redis.FormatStringSlice(s.Proxy.Commands().List()))
+	case "reload":
+		log.Warningf("audit: %s PROXY COMMANDS reload", s.Conn.RemoteAddr())
+		changes, err := s.Proxy.ReloadCommands()
+		if err != nil {
+			s.write2client([]byte(fmt.Sprintf("-%s\r\n", err)))
+			return
+		}
+		s.write2client(redis.FormatStringSlice(changes))
+	default:
+		s.write2client([]byte("-wrong proxy commands op type\r\n"))
+	}
+}
+
+func (s *Session) proxyEvents(req *redis.Request) {
+	n := 20
+	if len(req.Args()) == 2 {
+		v, err := strconv.Atoi(req.Args()[1])
+		if err != nil || v <= 0 {
+			s.write2client([]byte("-unavailable n, must be positive\r\n"))
+			return
+		}
+		n = v
+	}
+
+	// n is clamped by Last, never used as capacity
+	events := s.Proxy.Events.Last(n)
+	r := make([]string, 0, len(events))
+	for _, e := range events {
+		r = append(r, e.String())
+	}
+	s.write2client(redis.FormatStringSlice(r))
+}
+
+func (s *Session) proxyKeyStats(req *redis.Request) {
+	ks := s.Proxy.KeyStats
+	if ks == nil {
+		s.write2client([]byte("-keystats not enabled\r\n"))
+		return
+	}
+
+	n := 10
+	if len(req.Args()) == 2 {
+		v, err := strconv.Atoi(req.Args()[1])
+		if err != nil || v <= 0 {
+			s.write2client([]byte("-unavailable n, must be positive\r\n"))
+			return
+		}
+		n = v
+	}
+
+	// n is clamped by HotKeys and BigKeys, never used as capacity
+	var r []string
+	if strings.ToLower(req.Args()[0]) == "hotkeys" {
+		keys := ks.HotKeys(n)
+		r = make([]string, 0, len(keys))
+		for _, k := range keys {
+			r = append(r, fmt.Sprintf("%s:%d", k.Key, k.Value))
+		}
+	} else {
+		keys := ks.BigKeys(n)
+		r = make([]string, 0, len(keys))
+		for _, k := range keys {
+			r = append(r, fmt.Sprintf("%s %s:%d", k.Cmd, k.Key, k.Value))
+		}
+	}
+	s.write2client(redis.FormatStringSlice(r))
+}
+
 func (s *Session) proxyBlack(req *redis.Request) {
 	args := strings.ToLower(req.Args()[1])
-	// log.Warning(req.Args())
+	bl := s.Proxy.Blacklist
 	switch args {
 	// proxy black remove keyname
 	case "remove":
@@ -218,11 +394,8 @@ func (s *Session) proxyBlack(req *redis.Request) {
 			s.write2client([]byte(err))
 			return
 		}
-		// delete(BlackKeyLists, req.Args()[-1])
 		key := req.Args()[2]
-		if _, exists := BlackKeyLists[key]; exists {
-			log.Warning("remove black key ", key)
-			delete(BlackKeyLists, key)
+		if bl.Remove(key) {
 			s.write2client(OK_BYTES)
 		} else {
 			s.write2client([]byte("-remove key not exists\r\n"))
@@ -235,14 +408,31 @@ func (s *Session) proxyBlack(req *redis.Request) {
 			return
 		}
 		ks := make([]string, 0)
-		for k, _ := range BlackKeyLists {
-			ks = append(ks, k)
+		for _, b := range bl.List() {
+			ks = append(ks, b.Pattern)
 		}
 		d := redis.FormatStringSlice(ks)
 		s.write2client(d)
+	case "list":
+		// pattern match scope ttl, ttl -1 for config keys
+		if len(req.Args()) != 2 {
+			err := fmt.Sprintf("-%s\r\n", WrongArgumentCount)
+			s.write2client([]byte(err))
+			return
+		}
+		now := time.Now()
+		ks := make([]string, 0)
+		for _, b := range bl.List() {
+			ttl := int64(-1)
+			if d := b.TTL(now); d >= 0 {
+				ttl = int64((d + time.Second - 1) / time.Second)
+			}
+			ks = append(ks, fmt.Sprintf("%s %s %s %d", b.Pattern, b.Match, b.Scope, ttl))
+		}
+		s.write2client(redis.FormatStringSlice(ks))
 	case "set":
-		//proxy black set 3600 keyname1
-		if len(req.Args()) != 4 {
+		//proxy black set keyname 3600 [exact|prefix|glob] [all|write|read]
+		if len(req.Args()) < 4 || len(req.Args()) > 6 {
 			err := fmt.Sprintf("-%s\r\n", WrongArgumentCount)
 			s.write2client([]byte(err))
 			return
@@ -254,16 +444,19 @@ func (s *Session) proxyBlack(req *redis.Request) {
 			s.write2client([]byte(err))
 			return
 		}
-		if t > 86400 || t < 0 {
-			log.Warningf("black key: %s time unavailable %s", req.Args()[2], req.Args()[3])
-			s.write2client([]byte("-black time must between 0 ~ 86400\r\n"))
-			return
+		match, scope := BlackExact, BlackScopeAll
+		if len(req.Args()) > 4 {
+			match = strings.ToLower(req.Args()[4])
 		}
-		BlackKeyLists[req.Args()[2]] = &BlackKey{
-			Name:     req.Args()[2],
-			Startup:  time.Now(),
-			Deadline: time.Now().Add(time.Duration(t) * time.Second),
+		if len(req.Args()) > 5 {
+			scope = strings.ToLower(req.Args()[5])
+		}
+		if err := bl.Set(req.Args()[2], match, scope, t); err != nil {
+			log.Warningf("black key: %s failed %s", req.Args()[2], err)
+			s.write2client([]byte(fmt.Sprintf("-%s\r\n", err)))
+			return
 		}
+		log.Warningf("black key %s %s %s for %ds", req.Args()[2], match, scope, t)
 		s.write2client(OK_BYTES)
 		return
 	default:
@@ -0,0 +1,150 @@
+package redis
+
+import (
+	"sync"
+	"time"
+)
+
+var errNodeUnavailable = errorf("ERR node unavailable")
+
+const (
+	BreakerClosed = iota
+	BreakerHalfOpen
+	BreakerOpen
+)
+
+var breakerStateNames = []string{"closed", "half-open", "open"}
+
+func BreakerStateName(state int) string {
+	if state < 0 || state >= len(breakerStateNames) {
+		return "unknown"
+	}
+	return breakerStateNames[state]
+}
+
+// breaker is a circuit breaker of one cluster node. It opens when too
+// many commands fail or are slow in a window, then fails fast until the
+// cooldown is over. After that a single probe is let through (half-open),
+// breaker closes if probe succeeds, otherwise opens again.
+type breaker struct {
+	opt *ClusterOptions
+
+	mx       sync.Mutex
+	state    int
+	requests int
+	failures int
+	window   time.Time // start of current window
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(opt *ClusterOptions) *breaker {
+	return &breaker{
+		opt:    opt,
+		window: time.Now(),
+	}
+}
+
+func (b *breaker) State() int {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) > b.opt.getBreakerCooldown() {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// Allow reports whether a command may be sent to the node.
+func (b *breaker) Allow() bool {
+	if b.opt.BreakerErrorRate < 0 {
+		return true
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.opt.getBreakerCooldown() {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// Record counts result of a command and returns true if breaker
+// has just been opened.
+func (b *breaker) Record(err error, latency time.Duration) bool {
+	if b.opt.BreakerErrorRate < 0 {
+		return false
+	}
+
+	failed := isNodeError(err)
+	if slow := b.opt.BreakerSlowTime; slow > 0 && latency > slow {
+		failed = true
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+		if failed {
+			b.open()
+			return true
+		}
+		b.close()
+		return false
+	}
+	if b.state == BreakerOpen {
+		return false
+	}
+
+	if time.Since(b.window) > b.opt.getBreakerWindow() {
+		b.window = time.Now()
+		b.requests, b.failures = 0, 0
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+
+	if b.requests >= b.opt.getBreakerMinRequests() &&
+		float64(b.failures) >= float64(b.requests)*b.opt.getBreakerErrorRate() {
+		b.open()
+		return true
+	}
+	return false
+}
+
+func (b *breaker) open() {
+	b.state = BreakerOpen
+	b.openedAt = time.Now()
+}
+
+func (b *breaker) close() {
+	b.state = BreakerClosed
+	b.window = time.Now()
+	b.requests, b.failures = 0, 0
+}
+
+// isNodeError reports whether err means the node itself is broken, a
+// redis error reply (including nil) means the node works fine.
+func isNodeError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(redisError); ok {
+		return false
+	}
+	return true
+}
@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"fmt"
 	"math/rand"
 	"strings"
 	"sync"
@@ -10,36 +11,70 @@ import (
 	log "github.com/ngaut/logging"
 )
 
+// errNoSeeds is returned if there is no node to load slots from.
+var errNoSeeds = errorf("ERR no cluster nodes")
+
 type ClusterClient struct {
 	commandable
 
 	addrs []string
-	slots [][]string
+	// Seeds added by AddAddrs, not pruned until RemoveAddrs or Refresh.
+	pinned map[string]struct{}
+	slots  [][]string
 	//需要添加一个slave slots对应的关系表，这样可以做到读从库
-	slotsMx sync.RWMutex // Protects slots and addrs.
+	slotsMx sync.RWMutex // Protects slots, addrs, pinned, onChange and onEvent.
+
+	// Called after masters of slots or nodes changed.
+	onChange func()
+	// Called for each change found by reloading slots.
+	onEvent func(ClusterEvent)
 
 	clients   map[string]*Client
+	slaves    map[string]*slaveClient
+	breakers  map[string]*breaker
 	closed    bool
-	clientsMx sync.RWMutex // Protects clients and closed.
+	clientsMx sync.RWMutex // Protects clients, slaves, breakers and closed.
 
 	opt *ClusterOptions
+	// *ClusterOptions changed by SetOptions, opt is never written.
+	live atomic.Value
 
 	// Reports where slots reloading is in progress.
 	reloading uint32
+
+	// MOVED and ASK redirects followed, atomic.
+	moved int64
+	asks  int64
+
+	// TRYAGAIN, CLUSTERDOWN and LOADING retries per node.
+	retriesMx sync.Mutex
+	retries   map[string]int64
+
+	// Read only commands go to slaves if set, atomic.
+	slaveOk   int32
+	slaveNext uint32
 }
 
 // NewClusterClient returns a new Redis Cluster client as described in
 // http://redis.io/topics/cluster-spec.
 func NewClusterClient(opt *ClusterOptions) *ClusterClient {
 	client := &ClusterClient{
-		addrs:   opt.Addrs,
-		slots:   make([][]string, hashSlots),
-		clients: make(map[string]*Client),
-		opt:     opt,
+		addrs:    opt.Addrs,
+		pinned:   make(map[string]struct{}),
+		slots:    make([][]string, hashSlots),
+		clients:  make(map[string]*Client),
+		slaves:   make(map[string]*slaveClient),
+		breakers: make(map[string]*breaker),
+		retries:  make(map[string]int64),
+		opt:      opt,
 	}
+	client.SetSlaveOk(opt.SlaveOk)
 	client.commandable.process = client.process
 	client.reloadSlots()
 	go client.reaper()
+	if opt.RefreshInterval > 0 {
+		go client.refresher()
+	}
 	return client
 }
 
@@ -61,7 +96,80 @@ func (c *ClusterClient) Close() error {
 }
 
 func (c *ClusterClient) GetAddrs() []string {
-	return c.addrs
+	c.slotsMx.RLock()
+	defer c.slotsMx.RUnlock()
+	return append([]string(nil), c.addrs...)
+}
+
+// AddAddrs adds seed nodes, slots are reloaded from them later. They
+// are kept even if not in CLUSTER SLOTS, like a node not yet joined,
+// until RemoveAddrs or Refresh.
+func (c *ClusterClient) AddAddrs(addrs []string) {
+	var events []ClusterEvent
+	c.slotsMx.Lock()
+	for _, addr := range addrs {
+		c.pinned[addr] = struct{}{}
+		if !containsAddr(c.addrs, addr) {
+			c.addrs = append(c.addrs, addr)
+			events = append(events, ClusterEvent{Time: time.Now(), Type: EventSeedAdded, Addr: addr})
+		}
+	}
+	onEvent := c.onEvent
+	c.slotsMx.Unlock()
+
+	if onEvent != nil {
+		for _, e := range events {
+			onEvent(e)
+		}
+	}
+	c.lazyReloadSlots()
+}
+
+// RemoveAddrs removes seed nodes and closes their connections, nodes
+// owning slots can not be removed as reloading slots adds them again.
+// The last seed is kept, slots could not be loaded without it.
+func (c *ClusterClient) RemoveAddrs(addrs []string) error {
+	var events []ClusterEvent
+	c.slotsMx.Lock()
+	nodes := slotsNodes(c.slots)
+	for _, addr := range addrs {
+		if _, ok := nodes[addr]; ok {
+			c.slotsMx.Unlock()
+			return fmt.Errorf("%s owns slots", addr)
+		}
+	}
+	if len(removeAddrs(c.addrs, addrs)) == 0 {
+		c.slotsMx.Unlock()
+		return fmt.Errorf("can not remove all seeds")
+	}
+	for _, addr := range addrs {
+		if containsAddr(c.addrs, addr) {
+			events = append(events, ClusterEvent{Time: time.Now(), Type: EventSeedRemoved, Addr: addr})
+		}
+	}
+	c.addrs = removeAddrs(c.addrs, addrs)
+	for _, addr := range addrs {
+		delete(c.pinned, addr)
+	}
+	onEvent := c.onEvent
+	c.slotsMx.Unlock()
+
+	c.removeClients(addrs)
+	if onEvent != nil {
+		for _, e := range events {
+			onEvent(e)
+		}
+	}
+	return nil
+}
+
+// Refresh reloads slots now, unlike lazy reloads after MOVED. Seeds
+// added by AddAddrs are pruned too if not in CLUSTER SLOTS.
+func (c *ClusterClient) Refresh() error {
+	c.slotsMx.Lock()
+	c.pinned = make(map[string]struct{})
+	c.slotsMx.Unlock()
+	return c.loadSlots()
 }
 
 // getClient returns a Client for a given address.
@@ -86,7 +194,7 @@ func (c *ClusterClient) getClient(addr string) (*Client, error) {
 
 	client, ok = c.clients[addr]
 	if !ok {
-		opt := c.opt.clientOptions()
+		opt := c.options().clientOptions()
 		opt.Addr = addr
 		client = NewClient(opt)
 		c.clients[addr] = client
@@ -96,6 +204,133 @@ func (c *ClusterClient) getClient(addr string) (*Client, error) {
 	return client, nil
 }
 
+// SetOptions changes timeouts, redirects and retries at runtime. New
+// values apply to commands and connections created from now on, other
+// options like Addrs and PoolSize are ignored.
+func (c *ClusterClient) SetOptions(opt *ClusterOptions) {
+	c.clientsMx.Lock()
+	defer c.clientsMx.Unlock()
+
+	// commands in flight keep options they loaded
+	live := *c.options()
+	live.MaxRedirects = opt.MaxRedirects
+	live.CommandTimeouts = opt.CommandTimeouts
+	live.MaxRetries = opt.MaxRetries
+	live.DialTimeout = opt.DialTimeout
+	live.ReadTimeout = opt.ReadTimeout
+	live.WriteTimeout = opt.WriteTimeout
+	live.PoolTimeout = opt.PoolTimeout
+	live.IdleTimeout = opt.IdleTimeout
+	c.live.Store(&live)
+
+	for _, client := range c.clients {
+		live.setClientOptions(client.opt)
+	}
+	for _, client := range c.slaves {
+		live.setClientOptions(client.opt)
+	}
+}
+
+// options returns options in use, opt until SetOptions is called.
+func (c *ClusterClient) options() *ClusterOptions {
+	if opt, ok := c.live.Load().(*ClusterOptions); ok {
+		return opt
+	}
+	return c.opt
+}
+
+// PoolStats returns connections of every master and slave in use.
+func (c *ClusterClient) PoolStats() map[string]PoolStats {
+	c.clientsMx.RLock()
+	defer c.clientsMx.RUnlock()
+	stats := make(map[string]PoolStats, len(c.clients)+len(c.slaves))
+	for addr, client := range c.clients {
+		stats[addr] = client.PoolStats()
+	}
+	for addr, client := range c.slaves {
+		stats[addr] = client.PoolStats()
+	}
+	return stats
+}
+
+// SlotRanges returns the slot table, consecutive slots owned by the same
+// nodes are merged. Master is the first addr.
+func (c *ClusterClient) SlotRanges() []ClusterSlotInfo {
+	c.slotsMx.RLock()
+	defer c.slotsMx.RUnlock()
+
+	var ranges []ClusterSlotInfo
+	for slot, addrs := range c.slots {
+		if len(addrs) == 0 {
+			continue
+		}
+		if n := len(ranges); n > 0 {
+			last := &ranges[n-1]
+			if last.End == slot-1 && sameSlotAddrs(last.Addrs, addrs) {
+				last.End = slot
+				continue
+			}
+		}
+		ranges = append(ranges, ClusterSlotInfo{Start: slot, End: slot, Addrs: addrs})
+	}
+	return ranges
+}
+
+func sameSlotAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// nodeBreaker returns the circuit breaker for a given address.
+func (c *ClusterClient) nodeBreaker(addr string) *breaker {
+	c.clientsMx.RLock()
+	br, ok := c.breakers[addr]
+	c.clientsMx.RUnlock()
+	if ok {
+		return br
+	}
+
+	c.clientsMx.Lock()
+	br, ok = c.breakers[addr]
+	if !ok {
+		br = newBreaker(c.opt)
+		c.breakers[addr] = br
+	}
+	c.clientsMx.Unlock()
+	return br
+}
+
+func (c *ClusterClient) countRedirect(moved bool) {
+	if moved {
+		atomic.AddInt64(&c.moved, 1)
+	} else {
+		atomic.AddInt64(&c.asks, 1)
+	}
+}
+
+// RedirectStats returns MOVED and ASK redirects followed.
+func (c *ClusterClient) RedirectStats() (moved, asks int64) {
+	return atomic.LoadInt64(&c.moved), atomic.LoadInt64(&c.asks)
+}
+
+// BreakerStats returns circuit breaker state of every known node.
+func (c *ClusterClient) BreakerStats() map[string]int {
+	c.clientsMx.RLock()
+	stats := make(map[string]int, len(c.breakers))
+	for addr, br := range c.breakers {
+		stats[addr] = br.State()
+	}
+	c.clientsMx.RUnlock()
+	return stats
+}
+
 func (c *ClusterClient) slotAddrs(slot int) []string {
 	c.slotsMx.RLock()
 	addrs := c.slots[slot]
@@ -111,15 +346,61 @@ func (c *ClusterClient) slotMasterAddr(slot int) string {
 	return ""
 }
 
-// randomClient returns a Client for the first live node.
+// OnChange sets fn to be called after masters of slots changed.
+func (c *ClusterClient) OnChange(fn func()) {
+	c.slotsMx.Lock()
+	c.onChange = fn
+	c.slotsMx.Unlock()
+}
+
+// OnEvent sets fn to be called for each change of slots and nodes.
+func (c *ClusterClient) OnEvent(fn func(ClusterEvent)) {
+	c.slotsMx.Lock()
+	c.onEvent = fn
+	c.slotsMx.Unlock()
+}
+
+// ForEachMaster calls fn for each master which owns slots.
+func (c *ClusterClient) ForEachMaster(fn func(addr string, client *Client)) {
+	seen := make(map[string]struct{})
+	c.slotsMx.RLock()
+	for _, addrs := range c.slots {
+		if len(addrs) > 0 {
+			seen[addrs[0]] = struct{}{}
+		}
+	}
+	c.slotsMx.RUnlock()
+
+	for addr := range seen {
+		client, err := c.getClient(addr)
+		if err != nil {
+			continue
+		}
+		fn(addr, client)
+	}
+}
+
+// randomClient returns a Client for the first live node, nodes with
+// open breaker are skipped.
 func (c *ClusterClient) randomClient() (client *Client, err error) {
+	addrs := c.GetAddrs()
+	if len(addrs) == 0 {
+		return nil, errNoSeeds
+	}
 	for i := 0; i < 10; i++ {
-		n := rand.Intn(len(c.addrs))
-		client, err = c.getClient(c.addrs[n])
+		n := rand.Intn(len(addrs))
+		br := c.nodeBreaker(addrs[n])
+		if !br.Allow() {
+			err = errNodeUnavailable
+			continue
+		}
+		client, err = c.getClient(addrs[n])
 		if err != nil {
 			continue
 		}
+		start := time.Now()
 		err = client.ClusterInfo().Err()
+		br.Record(err, time.Since(start))
 		if err == nil {
 			return client, nil
 		}
@@ -129,9 +410,25 @@ func (c *ClusterClient) randomClient() (client *Client, err error) {
 
 func (c *ClusterClient) process(cmd Cmder) {
 	var ask bool
+	var retries int
+	var deadline time.Time
 
+	opt := c.options()
 	slot := hashSlot(cmd.clusterKey())
 
+	if cmd.readTimeout() == nil {
+		if timeout, ok := opt.CommandTimeouts[cmdName(cmd)]; ok {
+			cmd.setReadTimeout(timeout)
+			cmd.setWriteTimeout(timeout)
+		}
+	}
+
+	if c.SlaveOk() && isReadOnlyCmd(cmd) {
+		if c.processSlave(cmd, slot) {
+			return
+		}
+	}
+
 	addr := c.slotMasterAddr(slot)
 	client, err := c.getClient(addr)
 	if err != nil {
@@ -139,11 +436,19 @@ func (c *ClusterClient) process(cmd Cmder) {
 		return
 	}
 
-	for attempt := 0; attempt <= c.opt.getMaxRedirects(); attempt++ {
+	for attempt := 0; attempt <= opt.getMaxRedirects(); attempt++ {
 		if attempt > 0 {
 			cmd.reset()
 		}
 
+		// Fail fast if the node is known to be broken.
+		br := c.nodeBreaker(client.opt.Addr)
+		if !br.Allow() {
+			cmd.setErr(errNodeUnavailable)
+			return
+		}
+
+		start := time.Now()
 		if ask {
 			pipe := client.Pipeline()
 			pipe.Process(NewCmd("ASKING"))
@@ -154,6 +459,11 @@ func (c *ClusterClient) process(cmd Cmder) {
 			client.Process(cmd)
 		}
 
+		if br.Record(cmd.Err(), time.Since(start)) {
+			log.Warningf("redis: breaker of %s opened", client.opt.Addr)
+			c.lazyReloadSlots()
+		}
+
 		// If there is no (real) error, we are done!
 		err := cmd.Err()
 		if err == nil || err == Nil || err == TxFailedErr {
@@ -173,6 +483,7 @@ func (c *ClusterClient) process(cmd Cmder) {
 		var addr string
 		moved, ask, addr = isMovedError(err)
 		if moved || ask {
+			c.countRedirect(moved)
 			if moved && c.slotMasterAddr(slot) != addr {
 				c.lazyReloadSlots()
 			}
@@ -183,10 +494,64 @@ func (c *ClusterClient) process(cmd Cmder) {
 			continue
 		}
 
+		// The node will recover soon, so ask it again after backoff.
+		// These retries are bounded by RetryBudget, not MaxRedirects.
+		if isTransientError(err) && opt.RetryBudget > 0 {
+			if deadline.IsZero() {
+				deadline = time.Now().Add(opt.RetryBudget)
+			}
+			backoff := retryBackoff(retries)
+			if time.Now().Add(backoff).After(deadline) {
+				break
+			}
+			c.incrRetries(client.opt.Addr)
+			time.Sleep(backoff)
+			cmd.reset()
+			retries++
+			attempt--
+			continue
+		}
+
 		break
 	}
 }
 
+func (c *ClusterClient) incrRetries(addr string) {
+	c.retriesMx.Lock()
+	c.retries[addr]++
+	c.retriesMx.Unlock()
+}
+
+// RetryStats returns how many TRYAGAIN, CLUSTERDOWN and LOADING retries
+// were made per node since the client was created.
+func (c *ClusterClient) RetryStats() map[string]int64 {
+	c.retriesMx.Lock()
+	stats := make(map[string]int64, len(c.retries))
+	for addr, n := range c.retries {
+		stats[addr] = n
+	}
+	c.retriesMx.Unlock()
+	return stats
+}
+
+const (
+	minRetryBackoff = 8 * time.Millisecond
+	maxRetryBackoff = 512 * time.Millisecond
+)
+
+// retryBackoff returns exponential backoff with jitter for the retry,
+// the result is between half and full of the exponential value.
+func retryBackoff(retry int) time.Duration {
+	d := maxRetryBackoff
+	if retry < 8 {
+		d = minRetryBackoff << uint(retry)
+		if d > maxRetryBackoff {
+			d = maxRetryBackoff
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
 // Closes all clients and returns last error if there are any.
 func (c *ClusterClient) resetClients() (err error) {
 	for addr, client := range c.clients {
@@ -195,6 +560,12 @@ func (c *ClusterClient) resetClients() (err error) {
 		}
 		delete(c.clients, addr)
 	}
+	for addr, client := range c.slaves {
+		if e := client.Close(); e != nil {
+			err = e
+		}
+		delete(c.slaves, addr)
+	}
 	return err
 }
 
@@ -206,12 +577,19 @@ func (c *ClusterClient) setSlots(slots []ClusterSlotInfo) {
 		seen[addr] = struct{}{}
 	}
 
+	// slot slices are replaced, never modified, so old keeps them
+	old := make([][]string, hashSlots)
+	copy(old, c.slots)
 	for i := 0; i < hashSlots; i++ {
 		c.slots[i] = c.slots[i][:0]
 	}
+	changed := false
 	for _, info := range slots {
 		for slot := info.Start; slot <= info.End; slot++ {
 			c.slots[slot] = info.Addrs
+			if len(info.Addrs) > 0 && (len(old[slot]) == 0 || old[slot][0] != info.Addrs[0]) {
+				changed = true
+			}
 		}
 
 		for _, addr := range info.Addrs {
@@ -222,11 +600,104 @@ func (c *ClusterClient) setSlots(slots []ClusterSlotInfo) {
 		}
 	}
 
+	var (
+		events  []ClusterEvent
+		removed []string
+	)
+	// nothing to compare at startup, nor when closed
+	if len(slots) > 0 && len(slotsNodes(old)) > 0 {
+		events, removed = diffSlots(old, c.slots)
+		if len(removed) > 0 {
+			changed = true
+			c.addrs = removeAddrs(c.addrs, removed)
+			for _, addr := range removed {
+				delete(c.pinned, addr)
+			}
+		}
+	}
+
+	// seeds not owning slots are dead or not in cluster, unless added
+	// by operator
+	if len(slots) > 0 {
+		nodes := slotsNodes(c.slots)
+		var pruned []string
+		for _, addr := range c.addrs {
+			if _, ok := c.pinned[addr]; ok {
+				continue
+			}
+			if _, ok := nodes[addr]; !ok {
+				pruned = append(pruned, addr)
+				events = append(events, ClusterEvent{Time: time.Now(), Type: EventSeedPruned, Addr: addr})
+			}
+		}
+		if len(pruned) > 0 {
+			c.addrs = removeAddrs(c.addrs, pruned)
+			removed = append(removed, pruned...)
+		}
+	}
+
+	onChange := c.onChange
+	onEvent := c.onEvent
 	c.slotsMx.Unlock()
+
+	if len(removed) > 0 {
+		c.removeClients(removed)
+	}
+	if onEvent != nil {
+		for _, e := range events {
+			onEvent(e)
+		}
+	}
+	if changed && onChange != nil {
+		onChange()
+	}
+}
+
+// removeClients closes clients of nodes left the cluster.
+func (c *ClusterClient) removeClients(addrs []string) {
+	c.clientsMx.Lock()
+	defer c.clientsMx.Unlock()
+	if c.closed {
+		return
+	}
+	for _, addr := range addrs {
+		if client, ok := c.clients[addr]; ok {
+			client.Close()
+			delete(c.clients, addr)
+		}
+		if client, ok := c.slaves[addr]; ok {
+			client.Close()
+			delete(c.slaves, addr)
+		}
+		delete(c.breakers, addr)
+	}
+}
+
+// refresher reloads slots periodically, failovers and new nodes are
+// found without waiting for a MOVED.
+func (c *ClusterClient) refresher() {
+	ticker := time.NewTicker(c.opt.RefreshInterval)
+	defer ticker.Stop()
+	for _ = range ticker.C {
+		c.clientsMx.RLock()
+		closed := c.closed
+		c.clientsMx.RUnlock()
+		if closed {
+			break
+		}
+
+		if atomic.CompareAndSwapUint32(&c.reloading, 0, 1) {
+			c.reloadSlots()
+		}
+	}
 }
 
 func (c *ClusterClient) reloadSlots() {
 	defer atomic.StoreUint32(&c.reloading, 0)
+	c.loadSlots()
+}
+
+func (c *ClusterClient) loadSlots() error {
 	var (
 		client *Client
 		err    error
@@ -237,7 +708,7 @@ func (c *ClusterClient) reloadSlots() {
 		if err != nil {
 			log.Warningf("redis: randomClient failed for %d times: %s", i+1, err)
 			if i == 2 {
-				return
+				return err
 			}
 			continue
 		}
@@ -247,9 +718,10 @@ func (c *ClusterClient) reloadSlots() {
 	slots, err := client.ClusterSlots().Result()
 	if err != nil {
 		log.Warningf("redis: ClusterSlots failed: %s", err)
-		return
+		return err
 	}
 	c.setSlots(slots)
+	return nil
 }
 
 func (c *ClusterClient) lazyReloadSlots() {
@@ -281,6 +753,13 @@ func (c *ClusterClient) reaper() {
 			}
 		}
 
+		for _, client := range c.slaves {
+			pool := client.connPool
+			if cn := pool.First(); cn != nil {
+				pool.Put(cn)
+			}
+		}
+
 		c.clientsMx.RUnlock()
 	}
 }
@@ -298,10 +777,41 @@ type ClusterOptions struct {
 	// Default is 16
 	MaxRedirects int
 
+	// Sends read only commands to a slave of the slot, falls back
+	// to master on error. Can be changed later by SetSlaveOk.
+	SlaveOk bool
+	// How to pick the slave: random, roundrobin or latency.
+	// Default is random.
+	SlaveSelect string
+
+	// Circuit breaker of a node opens when BreakerErrorRate of commands
+	// fail within BreakerWindow, after at least BreakerMinRequests
+	// commands. Default rate is 0.5, -1 disables the breaker.
+	BreakerErrorRate   float64
+	BreakerMinRequests int
+	BreakerWindow      time.Duration
+	// Commands slower than this count as failed.
+	// Default is only errors count.
+	BreakerSlowTime time.Duration
+	// How long breaker stays open before probing the node again.
+	// Default is 5 seconds.
+	BreakerCooldown time.Duration
+
+	// Time budget for retrying a command failed with TRYAGAIN,
+	// CLUSTERDOWN or LOADING, with backoff between retries.
+	// Default is not to retry.
+	RetryBudget time.Duration
+
+	// Per command read and write timeouts, which override
+	// ReadTimeout and WriteTimeout, e.g. longer for DUMP RESTORE.
+	CommandTimeouts map[string]time.Duration
+
 	// Following options are copied from Options struct.
 
 	Password string
 
+	MaxRetries int
+
 	DialTimeout  time.Duration
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
@@ -309,6 +819,12 @@ type ClusterOptions struct {
 	PoolSize    int
 	PoolTimeout time.Duration
 	IdleTimeout time.Duration
+
+	MuxConns int
+
+	// Slots are reloaded this often besides after MOVED.
+	// Default is only after MOVED.
+	RefreshInterval time.Duration
 }
 
 func (opt *ClusterOptions) getMaxRedirects() int {
@@ -321,10 +837,44 @@ func (opt *ClusterOptions) getMaxRedirects() int {
 	return opt.MaxRedirects
 }
 
+func (opt *ClusterOptions) getBreakerErrorRate() float64 {
+	if opt.BreakerErrorRate == 0 {
+		return 0.5
+	}
+	return opt.BreakerErrorRate
+}
+
+func (opt *ClusterOptions) getBreakerMinRequests() int {
+	if opt.BreakerMinRequests == 0 {
+		return 20
+	}
+	return opt.BreakerMinRequests
+}
+
+func (opt *ClusterOptions) getBreakerWindow() time.Duration {
+	if opt.BreakerWindow == 0 {
+		return 10 * time.Second
+	}
+	return opt.BreakerWindow
+}
+
+func (opt *ClusterOptions) getBreakerCooldown() time.Duration {
+	if opt.BreakerCooldown == 0 {
+		return 5 * time.Second
+	}
+	return opt.BreakerCooldown
+}
+
+func (opt *ClusterOptions) setClientOptions(clopt *Options) {
+	clopt.setTimeouts(opt.clientOptions().newTimeouts())
+}
+
 func (opt *ClusterOptions) clientOptions() *Options {
 	return &Options{
 		Password: opt.Password,
 
+		MaxRetries: opt.MaxRetries,
+
 		DialTimeout:  opt.DialTimeout,
 		ReadTimeout:  opt.ReadTimeout,
 		WriteTimeout: opt.WriteTimeout,
@@ -332,6 +882,8 @@ func (opt *ClusterOptions) clientOptions() *Options {
 		PoolSize:    opt.PoolSize,
 		PoolTimeout: opt.PoolTimeout,
 		IdleTimeout: opt.IdleTimeout,
+
+		MuxConns: opt.MuxConns,
 	}
 }
 
@@ -357,3 +909,9 @@ func hashSlot(key string) int {
 	}
 	return int(crc16sum(key)) % hashSlots
 }
+
+// KeySlot returns the slot number for key the way CLUSTER KEYSLOT
+// does, an empty key always maps to slot 0.
+func KeySlot(key string) int {
+	return int(crc16sum(hashKey(key))) % hashSlots
+}
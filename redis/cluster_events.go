@@ -0,0 +1,127 @@
+package redis
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// Slots are owned by another shard, like resharding.
+	EventSlotMoved = "slot_moved"
+	// A slave of slots is promoted, like failover.
+	EventMasterChanged = "master_changed"
+	EventNodeAdded     = "node_added"
+	EventNodeRemoved   = "node_removed"
+
+	// Seeds changed by AddAddrs and RemoveAddrs, or dropped as they are
+	// not in CLUSTER SLOTS.
+	EventSeedAdded   = "seed_added"
+	EventSeedRemoved = "seed_removed"
+	EventSeedPruned  = "seed_pruned"
+)
+
+// ClusterEvent is a change of cluster topology found by reloading slots,
+// or a change of seeds. Slot events have the range Start ~ End moved from
+// Old to Addr, node and seed events only have Addr.
+type ClusterEvent struct {
+	Time       time.Time
+	Type       string
+	Start, End int
+	Addr       string
+	Old        string
+}
+
+func (e ClusterEvent) String() string {
+	switch e.Type {
+	case EventSlotMoved, EventMasterChanged:
+		return fmt.Sprintf("%s %s slots %d-%d %s -> %s",
+			e.Time.Format("2006-01-02 15:04:05"), e.Type, e.Start, e.End, e.Old, e.Addr)
+	}
+	return fmt.Sprintf("%s %s %s", e.Time.Format("2006-01-02 15:04:05"), e.Type, e.Addr)
+}
+
+// diffSlots compares slot tables, it returns events and nodes in old
+// but not in cur. Consecutive slots with the same change are merged.
+func diffSlots(old, cur [][]string) ([]ClusterEvent, []string) {
+	now := time.Now()
+	var events []ClusterEvent
+
+	for slot := 0; slot < len(cur); slot++ {
+		oldMaster, newMaster := slotMaster(old[slot]), slotMaster(cur[slot])
+		if oldMaster == newMaster {
+			continue
+		}
+		typ := EventSlotMoved
+		if oldMaster != "" && newMaster != "" && containsAddr(old[slot], newMaster) {
+			typ = EventMasterChanged
+		}
+
+		if n := len(events); n > 0 {
+			last := &events[n-1]
+			if last.End == slot-1 && last.Type == typ && last.Old == oldMaster && last.Addr == newMaster {
+				last.End = slot
+				continue
+			}
+		}
+		events = append(events, ClusterEvent{
+			Time:  now,
+			Type:  typ,
+			Start: slot,
+			End:   slot,
+			Addr:  newMaster,
+			Old:   oldMaster,
+		})
+	}
+
+	oldNodes, newNodes := slotsNodes(old), slotsNodes(cur)
+	for addr := range newNodes {
+		if _, ok := oldNodes[addr]; !ok {
+			events = append(events, ClusterEvent{Time: now, Type: EventNodeAdded, Addr: addr})
+		}
+	}
+	var removed []string
+	for addr := range oldNodes {
+		if _, ok := newNodes[addr]; !ok {
+			events = append(events, ClusterEvent{Time: now, Type: EventNodeRemoved, Addr: addr})
+			removed = append(removed, addr)
+		}
+	}
+	return events, removed
+}
+
+func slotMaster(addrs []string) string {
+	if len(addrs) > 0 {
+		return addrs[0]
+	}
+	return ""
+}
+
+// slotsNodes returns masters and slaves owning slots
+func slotsNodes(slots [][]string) map[string]struct{} {
+	nodes := make(map[string]struct{})
+	for _, addrs := range slots {
+		for _, addr := range addrs {
+			nodes[addr] = struct{}{}
+		}
+	}
+	return nodes
+}
+
+func containsAddr(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func removeAddrs(addrs, removed []string) []string {
+	r := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if !containsAddr(removed, addr) {
+			r = append(r, addr)
+		}
+	}
+	return r
+}
@@ -108,10 +108,12 @@ func (pipe *ClusterPipeline) execClusterCmds(
 			failedCmds[""] = append(failedCmds[""], cmds[i:]...)
 			break
 		} else if moved, ask, addr := isMovedError(err); moved {
+			pipe.cluster.countRedirect(true)
 			pipe.cluster.lazyReloadSlots()
 			cmd.reset()
 			failedCmds[addr] = append(failedCmds[addr], cmd)
 		} else if ask {
+			pipe.cluster.countRedirect(false)
 			cmd.reset()
 			failedCmds[addr] = append(failedCmds[addr], NewCmd("ASKING"), cmd)
 		} else if firstCmdErr == nil {
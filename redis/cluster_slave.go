@@ -0,0 +1,164 @@
+package redis
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	log "github.com/ngaut/logging"
+)
+
+const (
+	SlaveSelectRandom     = "random"
+	SlaveSelectRoundRobin = "roundrobin"
+	SlaveSelectLatency    = "latency"
+)
+
+// slaveErrPenalty is recorded as latency of a failed slave, so latency
+// policy avoids it until it answers quickly again.
+const slaveErrPenalty = time.Second
+
+// slaveClient is a client whose connections are in READONLY mode.
+type slaveClient struct {
+	*Client
+
+	// Moving average of command latency in microseconds, atomic.
+	latency int64
+}
+
+func (s *slaveClient) observe(d time.Duration) {
+	us := int64(d / time.Microsecond)
+	old := atomic.LoadInt64(&s.latency)
+	if old == 0 {
+		atomic.StoreInt64(&s.latency, us)
+		return
+	}
+	atomic.StoreInt64(&s.latency, old-old/8+us/8)
+}
+
+func (s *slaveClient) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.latency)) * time.Microsecond
+}
+
+// SetSlaveOk enables or disables reading from slaves.
+func (c *ClusterClient) SetSlaveOk(ok bool) {
+	if ok {
+		atomic.StoreInt32(&c.slaveOk, 1)
+	} else {
+		atomic.StoreInt32(&c.slaveOk, 0)
+	}
+}
+
+func (c *ClusterClient) SlaveOk() bool {
+	return atomic.LoadInt32(&c.slaveOk) == 1
+}
+
+// getSlaveClient returns a READONLY client for a given slave address.
+func (c *ClusterClient) getSlaveClient(addr string) (*slaveClient, error) {
+	c.clientsMx.RLock()
+	client, ok := c.slaves[addr]
+	if ok {
+		c.clientsMx.RUnlock()
+		return client, nil
+	}
+	c.clientsMx.RUnlock()
+
+	c.clientsMx.Lock()
+	if c.closed {
+		c.clientsMx.Unlock()
+		return nil, errClosed
+	}
+
+	client, ok = c.slaves[addr]
+	if !ok {
+		opt := c.options().clientOptions()
+		opt.Addr = addr
+		opt.ReadOnly = true
+		client = &slaveClient{Client: NewClient(opt)}
+		c.slaves[addr] = client
+	}
+	c.clientsMx.Unlock()
+
+	return client, nil
+}
+
+func (c *ClusterClient) slotSlaveAddrs(slot int) []string {
+	addrs := c.slotAddrs(slot)
+	if len(addrs) > 1 {
+		return addrs[1:]
+	}
+	return nil
+}
+
+func (c *ClusterClient) pickSlave(addrs []string) string {
+	switch c.opt.SlaveSelect {
+	case SlaveSelectRoundRobin:
+		n := atomic.AddUint32(&c.slaveNext, 1)
+		return addrs[int(n)%len(addrs)]
+	case SlaveSelectLatency:
+		best := ""
+		var min time.Duration
+		for _, addr := range addrs {
+			client, err := c.getSlaveClient(addr)
+			if err != nil {
+				continue
+			}
+			if l := client.Latency(); best == "" || l < min {
+				best, min = addr, l
+			}
+		}
+		if best != "" {
+			return best
+		}
+	}
+	return addrs[rand.Intn(len(addrs))]
+}
+
+// processSlave sends read only cmd to a slave of slot. It returns false
+// if there is no live slave, slave is broken, not ready or redirects,
+// caller should ask master then. Reply errors like WRONGTYPE are
+// returned as is.
+func (c *ClusterClient) processSlave(cmd Cmder, slot int) bool {
+	addrs := c.slotSlaveAddrs(slot)
+	live := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if c.nodeBreaker(addr).State() != BreakerOpen {
+			live = append(live, addr)
+		}
+	}
+	if len(live) == 0 {
+		return false
+	}
+
+	addr := c.pickSlave(live)
+	client, err := c.getSlaveClient(addr)
+	if err != nil {
+		return false
+	}
+	br := c.nodeBreaker(addr)
+	if !br.Allow() {
+		return false
+	}
+
+	start := time.Now()
+	client.Process(cmd)
+	latency := time.Since(start)
+	err = cmd.Err()
+	if br.Record(err, latency) {
+		log.Warningf("redis: breaker of slave %s opened", addr)
+	}
+	if moved, ask, _ := isMovedError(err); moved || ask {
+		// slot is moving, master knows where it is
+		cmd.reset()
+		return false
+	}
+	if !isNodeError(err) && !isSlaveNotReadyError(err) {
+		client.observe(latency)
+		return true
+	}
+
+	log.Warningf("redis: slave %s failed: %s, fallback to master", addr, err)
+	client.observe(slaveErrPenalty)
+	cmd.reset()
+	return false
+}
@@ -0,0 +1,142 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// slotsReply is CLUSTER SLOTS reply with all slots on addrs, master
+// first.
+func slotsReply(addrs ...string) string {
+	reply := fmt.Sprintf("*1\r\n*%d\r\n:0\r\n:%d\r\n", len(addrs)+2, hashSlots-1)
+	for _, addr := range addrs {
+		host, port, _ := net.SplitHostPort(addr)
+		reply += fmt.Sprintf("*2\r\n%s:%s\r\n", bulk(host), port)
+	}
+	return reply
+}
+
+func isClusterSlots(args []string) bool {
+	return strings.ToUpper(args[0]) == "CLUSTER" && strings.ToUpper(args[1]) == "SLOTS"
+}
+
+// fakeClusterNode replies CLUSTER SLOTS with all slots on owner, or on
+// itself if owner is nil.
+func fakeClusterNode(t *testing.T, owner *fakeServer) *fakeServer {
+	var s *fakeServer
+	s = newFakeServer(t, func(c net.Conn, args []string) string {
+		if isClusterSlots(args) {
+			o := owner
+			if o == nil {
+				o = s
+			}
+			return slotsReply(o.Addr())
+		}
+		return "+OK\r\n"
+	})
+	return s
+}
+
+func TestClusterKeepsAddedSeeds(t *testing.T) {
+	node := fakeClusterNode(t, nil)
+	defer node.Close()
+	// joined but owns no slots yet
+	empty := fakeClusterNode(t, node)
+	defer empty.Close()
+	client := NewClusterClient(&ClusterOptions{Addrs: []string{node.Addr()}})
+	defer client.Close()
+
+	client.AddAddrs([]string{empty.Addr()})
+	waitFor(t, "lazy reload by AddAddrs", func() bool {
+		return atomic.LoadUint32(&client.reloading) == 0
+	})
+	if err := client.loadSlots(); err != nil {
+		t.Fatal(err)
+	}
+	if addrs := client.GetAddrs(); !containsAddr(addrs, empty.Addr()) {
+		t.Fatalf("seed %s pruned by reload, addrs %v", empty.Addr(), addrs)
+	}
+
+	if err := client.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if addrs := client.GetAddrs(); containsAddr(addrs, empty.Addr()) {
+		t.Fatalf("seed %s kept after Refresh, addrs %v", empty.Addr(), addrs)
+	}
+}
+
+func TestClusterSlaveNotReadyFallsBackToMaster(t *testing.T) {
+	var master, slave *fakeServer
+	master = newFakeServer(t, func(c net.Conn, args []string) string {
+		if isClusterSlots(args) {
+			return slotsReply(master.Addr(), slave.Addr())
+		}
+		return bulk("master")
+	})
+	defer master.Close()
+	// slave replies GET with the error of its key
+	slave = newFakeServer(t, func(c net.Conn, args []string) string {
+		if isClusterSlots(args) {
+			return slotsReply(master.Addr(), slave.Addr())
+		}
+		if strings.ToUpper(args[0]) == "GET" {
+			return "-" + args[1] + "\r\n"
+		}
+		return "+OK\r\n"
+	})
+	defer slave.Close()
+	client := NewClusterClient(&ClusterOptions{Addrs: []string{master.Addr()}})
+	defer client.Close()
+	client.SetSlaveOk(true)
+
+	tests := []struct {
+		key string
+		val string
+		err string
+	}{
+		{"LOADING Redis is loading the dataset in memory", "master", ""},
+		{"MASTERDOWN Link with MASTER is down", "master", ""},
+		{"WRONGTYPE Operation against a key holding the wrong kind of value", "", "WRONGTYPE"},
+	}
+	for _, tt := range tests {
+		cmd := NewStringCmd("GET", tt.key)
+		client.Process(cmd)
+		if tt.err != "" {
+			if cmd.Err() == nil || !strings.HasPrefix(cmd.Err().Error(), tt.err) {
+				t.Errorf("GET %q got %v, want %s from slave", tt.key, cmd.Err(), tt.err)
+			}
+			continue
+		}
+		if cmd.Err() != nil || cmd.Val() != tt.val {
+			t.Errorf("GET %q got %q, %v, want %q", tt.key, cmd.Val(), cmd.Err(), tt.val)
+		}
+	}
+}
+
+func TestClusterKeepsLastSeed(t *testing.T) {
+	// unreachable, slots are never loaded
+	seed := "127.0.0.1:1"
+	client := NewClusterClient(&ClusterOptions{Addrs: []string{seed}})
+	defer client.Close()
+
+	if err := client.RemoveAddrs([]string{seed}); err == nil {
+		t.Fatal("last seed removed")
+	}
+	if addrs := client.GetAddrs(); len(addrs) != 1 || addrs[0] != seed {
+		t.Fatalf("addrs %v, want %s", addrs, seed)
+	}
+}
+
+func TestClusterWithoutSeeds(t *testing.T) {
+	client := NewClusterClient(&ClusterOptions{})
+	defer client.Close()
+
+	cmd := NewStringCmd("GET", "foo")
+	client.Process(cmd)
+	if cmd.Err() != errNoSeeds {
+		t.Fatalf("GET without seeds got %v, want %v", cmd.Err(), errNoSeeds)
+	}
+}
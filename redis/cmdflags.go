@@ -0,0 +1,153 @@
+package redis
+
+import (
+	"strings"
+)
+
+// Command flags, same meaning as flags in the reply of redis COMMAND.
+const (
+	FlagWrite = 1 << iota
+	FlagReadOnly
+)
+
+var cmdFlags = map[string]int{
+	// key
+	"DEL":       FlagWrite,
+	"TYPE":      FlagReadOnly,
+	"EXISTS":    FlagReadOnly,
+	"EXPIRE":    FlagWrite,
+	"EXPIREAT":  FlagWrite,
+	"TTL":       FlagReadOnly,
+	"PTTL":      FlagReadOnly,
+	"PERSIST":   FlagWrite,
+	"PEXPIRE":   FlagWrite,
+	"PEXPIREAT": FlagWrite,
+	"RENAME":    FlagWrite,
+	"RENAMENX":  FlagWrite,
+	"DUMP":      FlagReadOnly,
+	"RESTORE":   FlagWrite,
+	"SORT":      FlagWrite,
+	// bit
+	"SETBIT":   FlagWrite,
+	"BITCOUNT": FlagReadOnly,
+	"GETBIT":   FlagReadOnly,
+	// string
+	"GET":         FlagReadOnly,
+	"MGET":        FlagReadOnly,
+	"GETRANGE":    FlagReadOnly,
+	"GETSET":      FlagWrite,
+	"SET":         FlagWrite,
+	"MSET":        FlagWrite,
+	"MSETNX":      FlagWrite,
+	"SETEX":       FlagWrite,
+	"SETNX":       FlagWrite,
+	"PSETEX":      FlagWrite,
+	"SETRANGE":    FlagWrite,
+	"STRLEN":      FlagReadOnly,
+	"INCR":        FlagWrite,
+	"DECR":        FlagWrite,
+	"INCRBY":      FlagWrite,
+	"DECRBY":      FlagWrite,
+	"INCRBYFLOAT": FlagWrite,
+	"APPEND":      FlagWrite,
+	// hash
+	"HGET":         FlagReadOnly,
+	"HSET":         FlagWrite,
+	"HMGET":        FlagReadOnly,
+	"HMSET":        FlagWrite,
+	"HGETALL":      FlagReadOnly,
+	"HLEN":         FlagReadOnly,
+	"HDEL":         FlagWrite,
+	"HEXISTS":      FlagReadOnly,
+	"HINCRBY":      FlagWrite,
+	"HINCRBYFLOAT": FlagWrite,
+	"HKEYS":        FlagReadOnly,
+	"HSETNX":       FlagWrite,
+	"HVALS":        FlagReadOnly,
+	// set
+	"SADD":        FlagWrite,
+	"SCARD":       FlagReadOnly,
+	"SISMEMBER":   FlagReadOnly,
+	"SMEMBERS":    FlagReadOnly,
+	"SREM":        FlagWrite,
+	"SPOP":        FlagWrite,
+	"SRANDMEMBER": FlagReadOnly,
+	"SMOVE":       FlagWrite,
+	"SDIFF":       FlagReadOnly,
+	"SDIFFSTORE":  FlagWrite,
+	"SINTER":      FlagReadOnly,
+	"SINTERSTORE": FlagWrite,
+	// list
+	"LPUSH":     FlagWrite,
+	"RPUSH":     FlagWrite,
+	"LPOP":      FlagWrite,
+	"RPOP":      FlagWrite,
+	"RPOPLPUSH": FlagWrite,
+	"LINDEX":    FlagReadOnly,
+	"LINSERT":   FlagWrite,
+	"LTRIM":     FlagWrite,
+	"LRANGE":    FlagReadOnly,
+	"LLEN":      FlagReadOnly,
+	"LPUSHX":    FlagWrite,
+	"RPUSHX":    FlagWrite,
+	"LSET":      FlagWrite,
+	"LREM":      FlagWrite,
+	// zset
+	"ZADD":             FlagWrite,
+	"ZCARD":            FlagReadOnly,
+	"ZCOUNT":           FlagReadOnly,
+	"ZRANK":            FlagReadOnly,
+	"ZREVRANK":         FlagReadOnly,
+	"ZRANGE":           FlagReadOnly,
+	"ZREVRANGE":        FlagReadOnly,
+	"ZRANGEBYSCORE":    FlagReadOnly,
+	"ZREVRANGEBYSCORE": FlagReadOnly,
+	"ZREM":             FlagWrite,
+	"ZREMRANGEBYRANK":  FlagWrite,
+	"ZREMRANGEBYSCORE": FlagWrite,
+	"ZINCRBY":          FlagWrite,
+	"ZSCORE":           FlagReadOnly,
+	"ZRANGEBYLEX":      FlagReadOnly,
+	"ZLEXCOUNT":        FlagReadOnly,
+	"ZREMRANGEBYLEX":   FlagWrite,
+	"ZUNIONSTORE":      FlagWrite,
+	"ZINTERSTORE":      FlagWrite,
+	// finite zset
+	"XADD":        FlagWrite,
+	"XINCRBY":     FlagWrite,
+	"XRANGE":      FlagReadOnly,
+	"XREVRANGE":   FlagReadOnly,
+	"XSCORE":      FlagReadOnly,
+	"XREM":        FlagWrite,
+	"XCARD":       FlagReadOnly,
+	"XSETOPTIONS": FlagWrite,
+	"XGETFINITY":  FlagReadOnly,
+	"XGETPRUNING": FlagReadOnly,
+}
+
+// CommandFlags returns flags of the named command, 0 if unknown.
+func CommandFlags(name string) int {
+	return cmdFlags[strings.ToUpper(name)]
+}
+
+// IsReadOnly reports whether the named command never modifies data.
+func IsReadOnly(name string) bool {
+	return CommandFlags(name)&FlagReadOnly != 0
+}
+
+// IsWrite reports whether the named command may modify data.
+func IsWrite(name string) bool {
+	return CommandFlags(name)&FlagWrite != 0
+}
+
+func cmdName(cmd Cmder) string {
+	args := cmd.args()
+	if len(args) == 0 {
+		return ""
+	}
+	return strings.ToUpper(args[0])
+}
+
+func isReadOnlyCmd(cmd Cmder) bool {
+	return cmdFlags[cmdName(cmd)]&FlagReadOnly != 0
+}
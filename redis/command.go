@@ -38,6 +38,8 @@ type Cmder interface {
 
 	writeTimeout() *time.Duration
 	readTimeout() *time.Duration
+	setWriteTimeout(time.Duration)
+	setReadTimeout(time.Duration)
 	clusterKey() string
 
 	Err() error
@@ -3,7 +3,7 @@ package redis
 import (
 	"io"
 	"strconv"
-	// "strings"
+	"strings"
 	"time"
 
 	log "github.com/ngaut/logging"
@@ -239,6 +239,21 @@ func (c *commandable) OnRESTORE(req *Request) *StatusCmd {
 	return cmd
 }
 
+// OnSORT replies sorted elements, or their count if they are stored by
+// STORE
+func (c *commandable) OnSORT(req *Request) Cmder {
+	for _, arg := range req.cmd[2:] {
+		if strings.ToUpper(arg) == "STORE" {
+			cmd := NewIntCmd(req.cmd...)
+			c.Process(cmd)
+			return cmd
+		}
+	}
+	cmd := NewStringSliceCmd(req.cmd...)
+	c.Process(cmd)
+	return cmd
+}
+
 type Sort struct {
 	By            string
 	Offset, Count float64
@@ -1362,6 +1377,12 @@ func (c *commandable) ClusterInfo() *StringCmd {
 	return cmd
 }
 
+func (c *commandable) ReadOnly() *StatusCmd {
+	cmd := newKeylessStatusCmd("READONLY")
+	c.Process(cmd)
+	return cmd
+}
+
 func (c *commandable) ClusterFailover() *StatusCmd {
 	cmd := newKeylessStatusCmd("CLUSTER", "failover")
 	c.Process(cmd)
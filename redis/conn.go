@@ -38,7 +38,7 @@ func newConnDialer(opt *Options) func() (*conn, error) {
 }
 
 func (cn *conn) init(opt *Options) error {
-	if opt.Password == "" && opt.DB == 0 {
+	if opt.Password == "" && opt.DB == 0 && !opt.ReadOnly {
 		return nil
 	}
 
@@ -61,6 +61,12 @@ func (cn *conn) init(opt *Options) error {
 		}
 	}
 
+	if opt.ReadOnly {
+		if err := client.ReadOnly().Err(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
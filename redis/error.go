@@ -61,6 +61,32 @@ func isMovedError(err error) (moved bool, ask bool, addr string) {
 	return
 }
 
+// isTransientError reports whether err is returned by a node that will
+// recover soon: TRYAGAIN during resharding, CLUSTERDOWN during failover
+// or LOADING after restart. Such commands are worth retrying later.
+func isTransientError(err error) bool {
+	if _, ok := err.(redisError); !ok {
+		return false
+	}
+	s := err.Error()
+	return strings.HasPrefix(s, "TRYAGAIN") ||
+		strings.HasPrefix(s, "CLUSTERDOWN") ||
+		strings.HasPrefix(s, "LOADING")
+}
+
+// isSlaveNotReadyError reports whether err is returned by a slave not
+// able to serve reads now, like transient errors while syncing or
+// MASTERDOWN if slave-serve-stale-data is off. Master should be asked.
+func isSlaveNotReadyError(err error) bool {
+	if isTransientError(err) {
+		return true
+	}
+	if _, ok := err.(redisError); !ok {
+		return false
+	}
+	return strings.HasPrefix(err.Error(), "MASTERDOWN")
+}
+
 // shouldRetry reports whether failed command should be retried.
 func shouldRetry(err error) bool {
 	if err == nil {
@@ -0,0 +1,284 @@
+package redis
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/ngaut/logging"
+)
+
+var errConnBroken = errors.New("redis: multiplexed connection broken")
+
+const (
+	// Commands written to socket in one syscall at most.
+	muxMaxBatch = 128
+	// Commands written but not yet replied per connection at most.
+	muxMaxPending = 4096
+)
+
+// muxReq is a command waiting for its reply on a multiplexed connection.
+type muxReq struct {
+	cmd  Cmder
+	done chan struct{}
+}
+
+func (r *muxReq) fail(err error) {
+	r.cmd.setErr(err)
+	r.done <- struct{}{}
+}
+
+// muxPool keeps a few long-lived connections to one node, shared by all
+// callers like twemproxy does. Commands from many goroutines are written
+// back to back and flushed in batches, replies are matched to commands in
+// FIFO order, because redis replies in the order commands are received.
+type muxPool struct {
+	conns []*muxConn
+	next  uint32
+
+	// Goroutines sending to reqs of conns, atomic. Conns drain reqs
+	// until it is zero after close, so no command is left unanswered.
+	senders int32
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newMuxPool(opt *Options) *muxPool {
+	p := &muxPool{
+		conns:  make([]*muxConn, opt.MuxConns),
+		closed: make(chan struct{}),
+	}
+	dialer := newConnDialer(opt)
+	for i := range p.conns {
+		p.conns[i] = &muxConn{
+			pool:   p,
+			opt:    opt,
+			dialer: dialer,
+			reqs:   make(chan *muxReq, muxMaxBatch),
+			closed: p.closed,
+		}
+		go p.conns[i].loop()
+	}
+	return p
+}
+
+func (p *muxPool) process(cmd Cmder) {
+	m := p.pick()
+	req := &muxReq{cmd: cmd, done: make(chan struct{}, 1)}
+
+	// Closed must be checked after registering as sender, see drain.
+	atomic.AddInt32(&p.senders, 1)
+	select {
+	case <-p.closed:
+		atomic.AddInt32(&p.senders, -1)
+		cmd.setErr(errClosed)
+		return
+	default:
+	}
+	select {
+	case m.reqs <- req:
+	case <-p.closed:
+		atomic.AddInt32(&p.senders, -1)
+		cmd.setErr(errClosed)
+		return
+	}
+	atomic.AddInt32(&p.senders, -1)
+	<-req.done
+}
+
+// pick returns next connected conn round robin, so commands do not wait
+// for a conn dialing, or next conn if none is connected.
+func (p *muxPool) pick() *muxConn {
+	n := int(atomic.AddUint32(&p.next, 1))
+	for i := 0; i < len(p.conns); i++ {
+		if m := p.conns[(n+i)%len(p.conns)]; m.connected() {
+			return m
+		}
+	}
+	return p.conns[n%len(p.conns)]
+}
+
+func (p *muxPool) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+type muxConn struct {
+	pool   *muxPool
+	opt    *Options
+	dialer func() (*conn, error)
+	reqs   chan *muxReq
+	closed chan struct{}
+
+	// Set while serving a connection, atomic.
+	up int32
+}
+
+func (m *muxConn) connected() bool {
+	return atomic.LoadInt32(&m.up) == 1
+}
+
+// loop dials lazily on the first command and serves the connection
+// until it is broken, then waits for next command to dial again.
+func (m *muxConn) loop() {
+	for {
+		select {
+		case req := <-m.reqs:
+			cn, err := m.dial()
+			if err != nil {
+				// Commands queued meanwhile fail too instead of
+				// dialing one by one.
+				req.fail(err)
+				m.failQueued(err)
+				continue
+			}
+			atomic.StoreInt32(&m.up, 1)
+			m.serve(cn, req)
+			atomic.StoreInt32(&m.up, 0)
+		case <-m.closed:
+			m.drain()
+			return
+		}
+	}
+}
+
+// dial gives up when the pool is closed, a late conn is closed then.
+func (m *muxConn) dial() (*conn, error) {
+	type result struct {
+		cn  *conn
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		cn, err := m.dialer()
+		ch <- result{cn, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.cn, r.err
+	case <-m.closed:
+		go func() {
+			if r := <-ch; r.err == nil {
+				r.cn.Close()
+			}
+		}()
+		return nil, errClosed
+	}
+}
+
+// failQueued fails commands in reqs now, senders coming later are not
+// waited for.
+func (m *muxConn) failQueued(err error) {
+	for {
+		select {
+		case req := <-m.reqs:
+			req.fail(err)
+		default:
+			return
+		}
+	}
+}
+
+// drain fails commands still queued after the pool is closed. Senders
+// may still be between checking closed and sending, so it waits until
+// there is none.
+func (m *muxConn) drain() {
+	for {
+		m.failQueued(errClosed)
+		if atomic.LoadInt32(&m.pool.senders) == 0 {
+			m.failQueued(errClosed)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// serve is the writer of cn, replies are read by reader goroutine.
+func (m *muxConn) serve(cn *conn, first *muxReq) {
+	pending := make(chan *muxReq, muxMaxPending)
+	broken := make(chan struct{})
+	go m.reader(cn, pending, broken)
+	defer close(pending)
+
+	var buf []byte
+	batch := []*muxReq{first}
+	for {
+	collect:
+		for len(batch) < muxMaxBatch {
+			select {
+			case req := <-m.reqs:
+				batch = append(batch, req)
+			default:
+				break collect
+			}
+		}
+
+		buf = buf[:0]
+		for i, req := range batch {
+			buf = appendArgs(buf, req.cmd.args())
+			// Reader must know the command before its reply arrives.
+			select {
+			case pending <- req:
+			case <-broken:
+				for _, req := range batch[i:] {
+					req.fail(errConnBroken)
+				}
+				return
+			}
+		}
+
+		cn.WriteTimeout = m.opt.getWriteTimeout()
+		if _, err := cn.Write(buf); err != nil {
+			log.Warningf("redis: multiplexed write to %s failed: %s", m.opt.Addr, err)
+			// Reader fails the pending commands when it sees closed conn.
+			cn.Close()
+			return
+		}
+		batch = batch[:0]
+
+		select {
+		case req := <-m.reqs:
+			batch = append(batch, req)
+		case <-broken:
+			return
+		case <-m.closed:
+			// Reader closes cn after the last pending reply.
+			return
+		}
+	}
+}
+
+// reader parses replies in order. Once the connection is broken every
+// pending command gets the error, and broken is closed to stop writer.
+func (m *muxConn) reader(cn *conn, pending chan *muxReq, broken chan struct{}) {
+	var err error
+	for req := range pending {
+		if err != nil {
+			req.fail(err)
+			continue
+		}
+
+		if timeout := req.cmd.readTimeout(); timeout != nil {
+			cn.ReadTimeout = *timeout
+		} else {
+			cn.ReadTimeout = m.opt.getReadTimeout()
+		}
+		e := req.cmd.parseReply(cn.rd)
+		if _, ok := e.(redisError); e != nil && !ok {
+			err = e
+			cn.Close()
+			close(broken)
+		}
+		req.done <- struct{}{}
+	}
+	if err == nil {
+		cn.Close()
+		close(broken)
+	}
+}
@@ -0,0 +1,113 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEcho replies ECHO with its arg after delay, CLOSE drops the conn.
+func fakeEcho(t *testing.T, delay time.Duration) *fakeServer {
+	return newFakeServer(t, func(c net.Conn, args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "ECHO":
+			time.Sleep(delay)
+			return bulk(args[1])
+		case "CLOSE":
+			c.Close()
+			return ""
+		}
+		return "+OK\r\n"
+	})
+}
+
+func muxEcho(client *Client, v string) (string, error) {
+	cmd := NewStringCmd("ECHO", v)
+	client.Process(cmd)
+	return cmd.Val(), cmd.Err()
+}
+
+func TestMuxPipelinesInOrder(t *testing.T) {
+	server := fakeEcho(t, 0)
+	defer server.Close()
+	client := NewClient(&Options{Addr: server.Addr(), MuxConns: 2})
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				want := fmt.Sprintf("%d-%d", i, j)
+				if v, err := muxEcho(client, want); err != nil || v != want {
+					errs <- fmt.Errorf("ECHO %s got %q, %v", want, v, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestMuxRedialsBrokenConn(t *testing.T) {
+	server := fakeEcho(t, 0)
+	defer server.Close()
+	client := NewClient(&Options{Addr: server.Addr(), MuxConns: 1})
+	defer client.Close()
+
+	if v, err := muxEcho(client, "a"); err != nil || v != "a" {
+		t.Fatalf("ECHO got %q, %v", v, err)
+	}
+	cmd := NewStatusCmd("CLOSE")
+	client.Process(cmd)
+	if cmd.Err() == nil {
+		t.Fatal("CLOSE on dropped conn got no error")
+	}
+	waitFor(t, "ECHO on new conn", func() bool {
+		v, err := muxEcho(client, "b")
+		return err == nil && v == "b"
+	})
+}
+
+func TestMuxCloseWithCommandsInFlight(t *testing.T) {
+	server := fakeEcho(t, 2*time.Millisecond)
+	defer server.Close()
+	client := NewClient(&Options{Addr: server.Addr(), MuxConns: 2})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			want := fmt.Sprint(i)
+			if v, err := muxEcho(client, want); err == nil && v != want {
+				t.Errorf("ECHO %s got %q", want, v)
+			}
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	client.Close()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("commands still waiting after Close")
+	}
+	if _, err := muxEcho(client, "x"); err != errClosed {
+		t.Fatalf("ECHO after Close got %v, want %v", err, errClosed)
+	}
+}
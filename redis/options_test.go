@@ -0,0 +1,85 @@
+package redis
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSlowNode replies GET after delay, it owns all slots as a cluster
+// node.
+func fakeSlowNode(t *testing.T, delay time.Duration) *fakeServer {
+	var s *fakeServer
+	s = newFakeServer(t, func(c net.Conn, args []string) string {
+		switch {
+		case isClusterSlots(args):
+			return slotsReply(s.Addr())
+		case strings.ToUpper(args[0]) == "GET":
+			time.Sleep(delay)
+			return bulk("v")
+		}
+		return "+OK\r\n"
+	})
+	return s
+}
+
+func TestSetOptions(t *testing.T) {
+	node := fakeSlowNode(t, 200*time.Millisecond)
+	defer node.Close()
+	slave := fakeSlowNode(t, 200*time.Millisecond)
+	defer slave.Close()
+	sentinel := newFakeSentinel(t, node.Addr())
+	sentinel.slaves[slave.Addr()] = "slave"
+	defer sentinel.Close()
+
+	const slow, fast = 2 * time.Second, 50 * time.Millisecond
+
+	client := NewClient(&Options{Addr: node.Addr(), ReadTimeout: slow})
+	defer client.Close()
+	failover := NewFailoverClient(&FailoverOptions{
+		MasterName:    "mymaster",
+		SentinelAddrs: []string{sentinel.Addr()},
+		ReadTimeout:   slow,
+		SlaveOk:       true,
+	})
+	defer failover.Close()
+	ring, err := NewRing(&RingOptions{
+		Shards:      []RingShard{{Name: "redis1", Addr: node.Addr(), Weight: 1}},
+		ReadTimeout: slow,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ring.Close()
+	cluster := NewClusterClient(&ClusterOptions{Addrs: []string{node.Addr()}, ReadTimeout: slow})
+	defer cluster.Close()
+
+	tests := []struct {
+		name   string
+		client interface {
+			Process(Cmder)
+		}
+		setOptions func()
+	}{
+		{"client", client, func() { client.SetOptions(&Options{ReadTimeout: fast}) }},
+		// slave and master both time out
+		{"failover", failover, func() { failover.SetOptions(&FailoverOptions{ReadTimeout: fast}) }},
+		{"ring", ring, func() { ring.SetOptions(&RingOptions{ReadTimeout: fast}) }},
+		{"cluster", cluster, func() { cluster.SetOptions(&ClusterOptions{ReadTimeout: fast}) }},
+	}
+	for _, tt := range tests {
+		cmd := NewStringCmd("GET", "k")
+		tt.client.Process(cmd)
+		if err := cmd.Err(); err != nil {
+			t.Fatalf("%s: GET with read timeout %s got %v", tt.name, slow, err)
+		}
+
+		tt.setOptions()
+		cmd = NewStringCmd("GET", "k")
+		tt.client.Process(cmd)
+		if !isNetworkError(cmd.Err()) {
+			t.Errorf("%s: GET with read timeout %s got %q, %v, want timeout", tt.name, fast, cmd.Val(), cmd.Err())
+		}
+	}
+}
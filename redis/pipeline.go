@@ -65,7 +65,7 @@ func (pipe *Pipeline) Exec() (cmds []Cmder, retErr error) {
 	pipe.cmds = make([]Cmder, 0, 10)
 
 	failedCmds := cmds
-	for i := 0; i <= pipe.client.opt.MaxRetries; i++ {
+	for i := 0; i <= pipe.client.opt.getMaxRetries(); i++ {
 		cn, err := pipe.client.conn()
 		if err != nil {
 			setCmdsErr(failedCmds, err)
@@ -23,6 +23,7 @@ type pool interface {
 	Remove(*conn) error
 	Len() int
 	FreeLen() int
+	Timeouts() int64
 	Close() error
 }
 
@@ -130,6 +131,9 @@ type connPool struct {
 
 	_closed int32
 
+	// Gets timed out waiting for free connection, atomic.
+	timeouts int64
+
 	lastDialErr error
 }
 
@@ -238,6 +242,7 @@ func (p *connPool) Get() (*conn, error) {
 		return cn, nil
 	}
 
+	atomic.AddInt64(&p.timeouts, 1)
 	return nil, errPoolTimeout
 }
 
@@ -271,6 +276,11 @@ func (p *connPool) Len() int {
 	return p.conns.Len()
 }
 
+// Timeouts returns number of waits for a free connection timed out.
+func (p *connPool) Timeouts() int64 {
+	return atomic.LoadInt64(&p.timeouts)
+}
+
 // FreeLen returns number of free connections.
 func (p *connPool) FreeLen() int {
 	return len(p.freeConns)
@@ -416,6 +426,10 @@ func (p *singleConnPool) FreeLen() int {
 	return 0
 }
 
+func (p *singleConnPool) Timeouts() int64 {
+	return p.pool.Timeouts()
+}
+
 func (p *singleConnPool) Close() error {
 	defer p.mx.Unlock()
 	p.mx.Lock()
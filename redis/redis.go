@@ -3,6 +3,7 @@ package redis
 import (
 	"fmt"
 	"net"
+	"sync/atomic"
 	"time"
 
 	log "github.com/ngaut/logging"
@@ -11,12 +12,43 @@ import (
 type baseClient struct {
 	connPool pool
 	opt      *Options
+
+	// Commands go through multiplexed connections if set, connPool
+	// is still used by pipelines and pubsub.
+	mux *muxPool
 }
 
 func (c *baseClient) String() string {
 	return fmt.Sprintf("Redis<%s db:%d>", c.opt.Addr, c.opt.DB)
 }
 
+// PoolStats is connections of a node.
+type PoolStats struct {
+	Conns    int   `json:"conns"`    // open connections
+	Free     int   `json:"free"`     // idle connections in pool
+	Timeouts int64 `json:"timeouts"` // waits for free connection timed out
+}
+
+// PoolStats returns connections of the connection pool.
+func (c *baseClient) PoolStats() PoolStats {
+	return PoolStats{
+		Conns:    c.connPool.Len(),
+		Free:     c.connPool.FreeLen(),
+		Timeouts: c.connPool.Timeouts(),
+	}
+}
+
+// CommandHook is called after a command is processed by a node, err is
+// nil for redis: nil replies.
+type CommandHook func(addr string, d time.Duration, err error)
+
+var commandHook atomic.Value
+
+// SetCommandHook sets hook of every client, like for metrics of nodes.
+func SetCommandHook(hook CommandHook) {
+	commandHook.Store(hook)
+}
+
 func (c *baseClient) conn() (*conn, error) {
 	return c.connPool.Get()
 }
@@ -38,7 +70,28 @@ func (c *baseClient) putConn(cn *conn, ei error) {
 }
 
 func (c *baseClient) process(cmd Cmder) {
-	for i := 0; i <= c.opt.MaxRetries; i++ {
+	hook, _ := commandHook.Load().(CommandHook)
+	if hook == nil {
+		c.processCmd(cmd)
+		return
+	}
+
+	start := time.Now()
+	c.processCmd(cmd)
+	err := cmd.Err()
+	if err == Nil {
+		err = nil
+	}
+	hook(c.opt.Addr, time.Since(start), err)
+}
+
+func (c *baseClient) processCmd(cmd Cmder) {
+	if c.mux != nil {
+		c.mux.process(cmd)
+		return
+	}
+
+	for i := 0; i <= c.opt.getMaxRetries(); i++ {
 		if i > 0 {
 			cmd.reset()
 		}
@@ -52,13 +105,13 @@ func (c *baseClient) process(cmd Cmder) {
 		if timeout := cmd.writeTimeout(); timeout != nil {
 			cn.WriteTimeout = *timeout
 		} else {
-			cn.WriteTimeout = c.opt.WriteTimeout
+			cn.WriteTimeout = c.opt.getWriteTimeout()
 		}
 
 		if timeout := cmd.readTimeout(); timeout != nil {
 			cn.ReadTimeout = *timeout
 		} else {
-			cn.ReadTimeout = c.opt.ReadTimeout
+			cn.ReadTimeout = c.opt.getReadTimeout()
 		}
 
 		if err := cn.writeCmds(cmd); err != nil {
@@ -82,6 +135,9 @@ func (c *baseClient) process(cmd Cmder) {
 
 // Close closes the client, releasing any open resources.
 func (c *baseClient) Close() error {
+	if c.mux != nil {
+		c.mux.Close()
+	}
 	return c.connPool.Close()
 }
 
@@ -104,6 +160,10 @@ type Options struct {
 	// A database to be selected after connecting to server.
 	DB int64
 
+	// Sends READONLY after connecting, so a cluster slave serves
+	// read queries for the slots of its master.
+	ReadOnly bool
+
 	// The maximum number of retries before giving up.
 	// Default is to not retry failed commands.
 	MaxRetries int
@@ -129,6 +189,96 @@ type Options struct {
 	// connections. Should be less than server's timeout.
 	// Default is to not close idle connections.
 	IdleTimeout time.Duration
+
+	// The number of long-lived connections shared by all commands,
+	// which are pipelined and replied in FIFO order.
+	// Default is to use one pooled connection per command.
+	MuxConns int
+
+	// *timeouts replacing retries and timeouts above at runtime, fields
+	// are never written once client is created.
+	live atomic.Value
+}
+
+// timeouts are options of Options changed by setTimeouts.
+type timeouts struct {
+	maxRetries int
+
+	dial, read, write, pool, idle time.Duration
+}
+
+// setTimeouts replaces retries and timeouts of commands and connections
+// from now on.
+func (opt *Options) setTimeouts(t *timeouts) {
+	opt.live.Store(t)
+}
+
+// newTimeouts returns retries and timeouts set in fields of opt.
+func (opt *Options) newTimeouts() *timeouts {
+	return &timeouts{
+		maxRetries: opt.MaxRetries,
+
+		dial:  opt.DialTimeout,
+		read:  opt.ReadTimeout,
+		write: opt.WriteTimeout,
+		pool:  opt.PoolTimeout,
+		idle:  opt.IdleTimeout,
+	}
+}
+
+// clone copies opt with retries and timeouts in use, opt is not copied
+// as a struct as setTimeouts may store to it meanwhile.
+func (opt *Options) clone() *Options {
+	o := &Options{
+		Network:  opt.Network,
+		Addr:     opt.Addr,
+		Dialer:   opt.Dialer,
+		Password: opt.Password,
+		DB:       opt.DB,
+		ReadOnly: opt.ReadOnly,
+
+		MaxRetries: opt.MaxRetries,
+
+		DialTimeout:  opt.DialTimeout,
+		ReadTimeout:  opt.ReadTimeout,
+		WriteTimeout: opt.WriteTimeout,
+
+		PoolSize:    opt.PoolSize,
+		PoolTimeout: opt.PoolTimeout,
+		IdleTimeout: opt.IdleTimeout,
+
+		MuxConns: opt.MuxConns,
+	}
+	if t := opt.timeouts(); t != nil {
+		o.setTimeouts(t)
+	}
+	return o
+}
+
+func (opt *Options) timeouts() *timeouts {
+	t, _ := opt.live.Load().(*timeouts)
+	return t
+}
+
+func (opt *Options) getMaxRetries() int {
+	if t := opt.timeouts(); t != nil {
+		return t.maxRetries
+	}
+	return opt.MaxRetries
+}
+
+func (opt *Options) getReadTimeout() time.Duration {
+	if t := opt.timeouts(); t != nil {
+		return t.read
+	}
+	return opt.ReadTimeout
+}
+
+func (opt *Options) getWriteTimeout() time.Duration {
+	if t := opt.timeouts(); t != nil {
+		return t.write
+	}
+	return opt.WriteTimeout
 }
 
 func (opt *Options) getNetwork() string {
@@ -155,20 +305,31 @@ func (opt *Options) getPoolSize() int {
 }
 
 func (opt *Options) getDialTimeout() time.Duration {
-	if opt.DialTimeout == 0 {
+	d := opt.DialTimeout
+	if t := opt.timeouts(); t != nil {
+		d = t.dial
+	}
+	if d == 0 {
 		return 5 * time.Second
 	}
-	return opt.DialTimeout
+	return d
 }
 
 func (opt *Options) getPoolTimeout() time.Duration {
-	if opt.PoolTimeout == 0 {
+	d := opt.PoolTimeout
+	if t := opt.timeouts(); t != nil {
+		d = t.pool
+	}
+	if d == 0 {
 		return 1 * time.Second
 	}
-	return opt.PoolTimeout
+	return d
 }
 
 func (opt *Options) getIdleTimeout() time.Duration {
+	if t := opt.timeouts(); t != nil {
+		return t.idle
+	}
 	return opt.IdleTimeout
 }
 
@@ -187,7 +348,18 @@ func newClient(opt *Options, pool pool) *Client {
 	}
 }
 
+// SetOptions changes retries and timeouts at runtime. New values apply
+// to commands and connections created from now on, other options like
+// Addr and PoolSize are ignored.
+func (c *Client) SetOptions(opt *Options) {
+	c.opt.setTimeouts(opt.newTimeouts())
+}
+
 func NewClient(opt *Options) *Client {
 	pool := newConnPool(opt)
-	return newClient(opt, pool)
+	client := newClient(opt, pool)
+	if opt.MuxConns > 0 {
+		client.mux = newMuxPool(opt)
+	}
+	return client
 }
@@ -18,6 +18,13 @@ func (r *Request) Name() string {
 	return ""
 }
 
+// SetName replaces command name, args are kept
+func (r *Request) SetName(name string) {
+	if len(r.cmd) > 0 {
+		r.cmd[0] = name
+	}
+}
+
 func (r *Request) Len() int {
 	return len(r.cmd)
 }
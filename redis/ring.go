@@ -3,10 +3,11 @@ package redis
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/dongzerun/smartproxy/redis/internal/consistenthash"
 	log "github.com/ngaut/logging"
 )
 
@@ -14,11 +15,37 @@ var (
 	errRingShardsDown = errors.New("redis: all ring shards are down")
 )
 
+// RingShard is a named shard of the ring.
+type RingShard struct {
+	// Name is hashed to place the shard on ketama continuum, defaults
+	// to Addr like twemproxy does.
+	Name   string
+	Addr   string
+	Weight int
+}
+
 // RingOptions are used to configure a ring client and should be
 // passed to NewRing.
 type RingOptions struct {
 	// A map of name => host:port addresses of ring shards.
 	Addrs map[string]string
+	// Shards in order, which matters for modula distribution. Addrs
+	// are appended sorted by name with weight 1.
+	Shards []RingShard
+
+	// ketama(default) or modula.
+	Distribution string
+	// fnv1a_64(default), md5, crc32 or murmur.
+	Hash string
+	// Two chars like "{}", only the part of key between them is hashed.
+	HashTag string
+
+	// Ejects a shard from the ring after FailureLimit(default 2)
+	// consecutive failures and adds it back after RetryTimeout
+	// (default 30s). Without it keys of a dead shard just fail.
+	AutoEject    bool
+	FailureLimit int
+	RetryTimeout time.Duration
 
 	// Following options are copied from Options struct.
 
@@ -36,11 +63,51 @@ type RingOptions struct {
 	IdleTimeout time.Duration
 }
 
+func (opt *RingOptions) getFailureLimit() int {
+	if opt.FailureLimit <= 0 {
+		return 2
+	}
+	return opt.FailureLimit
+}
+
+func (opt *RingOptions) getRetryTimeout() time.Duration {
+	if opt.RetryTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return opt.RetryTimeout
+}
+
+func (opt *RingOptions) shards() []RingShard {
+	shards := make([]RingShard, 0, len(opt.Shards)+len(opt.Addrs))
+	shards = append(shards, opt.Shards...)
+
+	names := make([]string, 0, len(opt.Addrs))
+	for name := range opt.Addrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		shards = append(shards, RingShard{Name: name, Addr: opt.Addrs[name]})
+	}
+
+	for i := range shards {
+		if shards[i].Name == "" {
+			shards[i].Name = shards[i].Addr
+		}
+		if shards[i].Weight <= 0 {
+			shards[i].Weight = 1
+		}
+	}
+	return shards
+}
+
 func (opt *RingOptions) clientOptions() *Options {
 	return &Options{
 		DB:       opt.DB,
 		Password: opt.Password,
 
+		MaxRetries: opt.MaxRetries,
+
 		DialTimeout:  opt.DialTimeout,
 		ReadTimeout:  opt.ReadTimeout,
 		WriteTimeout: opt.WriteTimeout,
@@ -51,9 +118,42 @@ func (opt *RingOptions) clientOptions() *Options {
 	}
 }
 
+// SetOptions changes retries and timeouts at runtime. New values apply
+// to commands and connections created from now on, other options like
+// Shards and Hash are ignored.
+func (ring *Ring) SetOptions(opt *RingOptions) {
+	ring.mx.Lock()
+	defer ring.mx.Unlock()
+
+	// pipelines in flight keep options they loaded
+	live := *ring.options()
+	live.MaxRetries = opt.MaxRetries
+	live.DialTimeout = opt.DialTimeout
+	live.ReadTimeout = opt.ReadTimeout
+	live.WriteTimeout = opt.WriteTimeout
+	live.PoolTimeout = opt.PoolTimeout
+	live.IdleTimeout = opt.IdleTimeout
+	ring.live.Store(&live)
+
+	t := live.clientOptions().newTimeouts()
+	for _, shard := range ring.shards {
+		shard.Client.opt.setTimeouts(t)
+	}
+}
+
+func (ring *Ring) options() *RingOptions {
+	if opt, ok := ring.live.Load().(*RingOptions); ok {
+		return opt
+	}
+	return ring.opt
+}
+
 type ringShard struct {
+	RingShard
 	Client *Client
-	down   int
+
+	failures  int32 // consecutive, atomic
+	nextRetry time.Time
 }
 
 func (shard *ringShard) String() string {
@@ -63,43 +163,26 @@ func (shard *ringShard) String() string {
 	} else {
 		state = "down"
 	}
-	return fmt.Sprintf("%s is %s", shard.Client, state)
+	return fmt.Sprintf("%s(%s) is %s", shard.Name, shard.Addr, state)
 }
 
 func (shard *ringShard) IsDown() bool {
-	const threshold = 5
-	return shard.down >= threshold
+	return time.Now().Before(shard.nextRetry)
 }
 
 func (shard *ringShard) IsUp() bool {
 	return !shard.IsDown()
 }
 
-// Vote votes to set shard state and returns true if state was changed.
-func (shard *ringShard) Vote(up bool) bool {
-	if up {
-		changed := shard.IsDown()
-		shard.down = 0
-		return changed
-	}
-
-	if shard.IsDown() {
-		return false
-	}
-
-	shard.down++
-	return shard.IsDown()
-}
-
 // Ring is a Redis client that uses constistent hashing to distribute
 // keys across multiple Redis servers (shards).
 //
-// It monitors the state of each shard and removes dead shards from
-// the ring. When shard comes online it is added back to the ring. This
-// gives you maximum availability and partition tolerance, but no
-// consistency between different shards or even clients. Each client
-// uses shards that are available to the client and does not do any
-// coordination when shard state is changed.
+// Keys are placed the same way as twemproxy, with ketama or modula
+// distribution. With AutoEject a shard failed too many times is
+// removed from the ring and added back after the retry timeout, so
+// keys move to other shards meanwhile. This gives you maximum
+// availability and partition tolerance, but no consistency between
+// different shards or even clients.
 //
 // Ring should be used when you use multiple Redis servers for caching
 // and can tolerate losing data when one of the servers dies.
@@ -107,87 +190,174 @@ func (shard *ringShard) Vote(up bool) bool {
 type Ring struct {
 	commandable
 
-	opt       *RingOptions
-	nreplicas int
+	opt  *RingOptions
+	hash ringHashFunc
+	// *RingOptions changed by SetOptions, opt is never written.
+	live atomic.Value
 
-	mx     sync.RWMutex
-	hash   *consistenthash.Map
-	shards map[string]*ringShard
+	mx        sync.RWMutex
+	shards    []*ringShard
+	continuum *ringContinuum
+	ejected   int // shards out of continuum
+	onChange  func()
 
 	closed bool
 }
 
-func NewRing(opt *RingOptions) *Ring {
-	const nreplicas = 100
-	ring := &Ring{
-		opt:       opt,
-		nreplicas: nreplicas,
+func NewRing(opt *RingOptions) (*Ring, error) {
+	hash, err := ringHash(opt.Hash)
+	if err != nil {
+		return nil, err
+	}
+	switch opt.Distribution {
+	case "":
+		opt.Distribution = RingKetama
+	case RingKetama, RingModula:
+	default:
+		return nil, fmt.Errorf("redis: unknown ring distribution %q", opt.Distribution)
+	}
 
-		hash:   consistenthash.New(nreplicas, nil),
-		shards: make(map[string]*ringShard),
+	ring := &Ring{
+		opt:  opt,
+		hash: hash,
 	}
 	ring.commandable.process = ring.process
-	for name, addr := range opt.Addrs {
+	for _, s := range opt.shards() {
 		clopt := opt.clientOptions()
-		clopt.Addr = addr
-		ring.addClient(name, NewClient(clopt))
+		clopt.Addr = s.Addr
+		ring.shards = append(ring.shards, &ringShard{RingShard: s, Client: NewClient(clopt)})
 	}
+	ring.rebalance()
 	go ring.heartbeat()
-	return ring
+	return ring, nil
+}
+
+// Shards returns state of each shard, name => "addr up|down".
+func (ring *Ring) Shards() map[string]string {
+	ring.mx.RLock()
+	defer ring.mx.RUnlock()
+
+	m := make(map[string]string, len(ring.shards))
+	for _, shard := range ring.shards {
+		state := "up"
+		if shard.IsDown() {
+			state = "down"
+		}
+		m[shard.Name] = shard.Addr + " " + state
+	}
+	return m
 }
 
-func (ring *Ring) addClient(name string, cl *Client) {
+// OnChange sets fn to be called after shards ejected or added back.
+func (ring *Ring) OnChange(fn func()) {
 	ring.mx.Lock()
-	ring.hash.Add(name)
-	ring.shards[name] = &ringShard{Client: cl}
+	ring.onChange = fn
 	ring.mx.Unlock()
 }
 
-func (ring *Ring) getClient(key string) (*Client, error) {
+// PoolStats returns connections of every shard, ejected ones too.
+func (ring *Ring) PoolStats() map[string]PoolStats {
 	ring.mx.RLock()
+	defer ring.mx.RUnlock()
+	stats := make(map[string]PoolStats, len(ring.shards))
+	for _, shard := range ring.shards {
+		stats[shard.Addr] = shard.Client.PoolStats()
+	}
+	return stats
+}
 
-	if ring.closed {
-		return nil, errClosed
+// ForEachShard calls fn for each shard which is up.
+func (ring *Ring) ForEachShard(fn func(addr string, client *Client)) {
+	ring.mx.RLock()
+	var shards []*ringShard
+	for _, shard := range ring.shards {
+		if shard.IsUp() {
+			shards = append(shards, shard)
+		}
 	}
+	ring.mx.RUnlock()
 
-	name := ring.hash.Get(key)
-	if name == "" {
-		ring.mx.RUnlock()
-		return nil, errRingShardsDown
+	for _, shard := range shards {
+		fn(shard.Addr, shard.Client)
 	}
+}
 
-	if shard, ok := ring.shards[name]; ok {
-		ring.mx.RUnlock()
-		return shard.Client, nil
+func (ring *Ring) getShard(key string) (*ringShard, error) {
+	ring.mx.RLock()
+	defer ring.mx.RUnlock()
+
+	if ring.closed {
+		return nil, errClosed
 	}
 
-	ring.mx.RUnlock()
-	return nil, errRingShardsDown
+	i := ring.continuum.Get(ringKeyHash(ring.hash, key, ring.opt.HashTag))
+	if i < 0 {
+		return nil, errRingShardsDown
+	}
+	return ring.shards[i], nil
 }
 
 func (ring *Ring) process(cmd Cmder) {
-	cl, err := ring.getClient(hashKey(cmd.clusterKey()))
+	shard, err := ring.getShard(cmd.clusterKey())
 	if err != nil {
 		cmd.setErr(err)
 		return
 	}
-	cl.baseClient.process(cmd)
+	shard.Client.baseClient.process(cmd)
+	ring.record(shard, cmd.Err())
+}
+
+// record counts consecutive failures of shard and ejects it when
+// there are too many.
+func (ring *Ring) record(shard *ringShard, err error) {
+	if !isNodeError(err) {
+		if atomic.LoadInt32(&shard.failures) != 0 {
+			atomic.StoreInt32(&shard.failures, 0)
+		}
+		return
+	}
+
+	n := atomic.AddInt32(&shard.failures, 1)
+	if !ring.opt.AutoEject || int(n) < ring.opt.getFailureLimit() {
+		return
+	}
+
+	ring.mx.Lock()
+	if shard.IsDown() || ring.closed {
+		ring.mx.Unlock()
+		return
+	}
+	shard.nextRetry = time.Now().Add(ring.opt.getRetryTimeout())
+	ring.mx.Unlock()
+
+	log.Warningf("redis: ring shard ejected after %d failures: %s", n, shard)
+	ring.rebalance()
 }
 
-// rebalance removes dead shards from the ring.
+// rebalance rebuilds continuum over shards which are up.
 func (ring *Ring) rebalance() {
 	defer ring.mx.Unlock()
 	ring.mx.Lock()
 
-	ring.hash = consistenthash.New(ring.nreplicas, nil)
-	for name, shard := range ring.shards {
-		if shard.IsUp() {
-			ring.hash.Add(name)
+	if ring.closed {
+		return
+	}
+
+	live := make([]bool, len(ring.shards))
+	ring.ejected = 0
+	for i, shard := range ring.shards {
+		live[i] = shard.IsUp()
+		if !live[i] {
+			ring.ejected++
 		}
 	}
+	ring.continuum = newRingContinuum(ring.opt.Distribution, ring.shards, live)
+	if ring.onChange != nil {
+		go ring.onChange()
+	}
 }
 
-// heartbeat monitors state of each shard in the ring.
+// heartbeat adds ejected shards back to the ring after retry timeout.
 func (ring *Ring) heartbeat() {
 	ticker := time.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
@@ -201,17 +371,20 @@ func (ring *Ring) heartbeat() {
 			break
 		}
 
+		var down int
 		for _, shard := range ring.shards {
-			err := shard.Client.Ping().Err()
-			if shard.Vote(err == nil) {
-				log.Warningf("redis: ring shard state changed: %s", shard)
-				rebalance = true
+			if shard.IsDown() {
+				down++
 			}
 		}
+		if down != ring.ejected {
+			rebalance = true
+		}
 
 		ring.mx.RUnlock()
 
 		if rebalance {
+			log.Warningf("redis: ring shards down changed to %d", down)
 			ring.rebalance()
 		}
 	}
@@ -235,7 +408,7 @@ func (ring *Ring) Close() (retErr error) {
 			retErr = err
 		}
 	}
-	ring.hash = nil
+	ring.continuum = nil
 	ring.shards = nil
 
 	return retErr
@@ -297,25 +470,24 @@ func (pipe *RingPipeline) Exec() (cmds []Cmder, retErr error) {
 	cmds = pipe.cmds
 	pipe.cmds = make([]Cmder, 0, 10)
 
-	cmdsMap := make(map[string][]Cmder)
+	cmdsMap := make(map[*ringShard][]Cmder)
 	for _, cmd := range cmds {
-		name := pipe.ring.hash.Get(cmd.clusterKey())
-		cmdsMap[name] = append(cmdsMap[name], cmd)
+		shard, err := pipe.ring.getShard(cmd.clusterKey())
+		if err != nil {
+			cmd.setErr(err)
+			if retErr == nil {
+				retErr = err
+			}
+			continue
+		}
+		cmdsMap[shard] = append(cmdsMap[shard], cmd)
 	}
 
-	for i := 0; i <= pipe.ring.opt.MaxRetries; i++ {
-		failedCmdsMap := make(map[string][]Cmder)
-
-		for name, cmds := range cmdsMap {
-			client, err := pipe.ring.getClient(name)
-			if err != nil {
-				setCmdsErr(cmds, err)
-				if retErr == nil {
-					retErr = err
-				}
-				continue
-			}
+	for i := 0; i <= pipe.ring.options().MaxRetries; i++ {
+		failedCmdsMap := make(map[*ringShard][]Cmder)
 
+		for shard, cmds := range cmdsMap {
+			client := shard.Client
 			cn, err := client.conn()
 			if err != nil {
 				setCmdsErr(cmds, err)
@@ -334,7 +506,7 @@ func (pipe *RingPipeline) Exec() (cmds []Cmder, retErr error) {
 				retErr = err
 			}
 			if len(failedCmds) > 0 {
-				failedCmdsMap[name] = failedCmds
+				failedCmdsMap[shard] = failedCmds
 			}
 		}
 
@@ -0,0 +1,213 @@
+package redis
+
+import (
+	"crypto/md5"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Distributions and hash functions of Ring, the names and the key
+// placement are the same as twemproxy, so a pool can be moved from
+// twemproxy to Ring without remapping keys.
+const (
+	RingKetama = "ketama"
+	RingModula = "modula"
+
+	RingFnv1a64 = "fnv1a_64"
+	RingMd5     = "md5"
+	RingCrc32   = "crc32"
+	RingMurmur  = "murmur"
+)
+
+const (
+	ketamaPointsPerServer = 160
+	ketamaPointsPerHash   = 4
+)
+
+type ringHashFunc func(key string) uint32
+
+func ringHash(name string) (ringHashFunc, error) {
+	switch name {
+	case "", RingFnv1a64:
+		return hashFnv1a64, nil
+	case RingMd5:
+		return hashMd5, nil
+	case RingCrc32:
+		return hashCrc32, nil
+	case RingMurmur:
+		return hashMurmur, nil
+	}
+	return nil, fmt.Errorf("redis: unknown ring hash %q", name)
+}
+
+// hashFnv1a64 is fnv1a_64 of twemproxy, which is computed in 32 bits
+// and sign extends bytes of the key like C char does.
+func hashFnv1a64(key string) uint32 {
+	const (
+		offset = uint32(0xcbf29ce484222325 & 0xffffffff)
+		prime  = uint32(0x100000001b3 & 0xffffffff)
+	)
+	hash := offset
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(int32(int8(key[i])))
+		hash *= prime
+	}
+	return hash
+}
+
+// hashMd5 is the first 4 bytes of md5 in little endian.
+func hashMd5(key string) uint32 {
+	sum := md5.Sum([]byte(key))
+	return uint32(sum[3])<<24 | uint32(sum[2])<<16 | uint32(sum[1])<<8 | uint32(sum[0])
+}
+
+// hashCrc32 is crc32 of libmemcached, only 15 bits are used.
+func hashCrc32(key string) uint32 {
+	return (crc32.ChecksumIEEE([]byte(key)) >> 16) & 0x7fff
+}
+
+// hashMurmur is murmur2 of twemproxy, seeded by length of the key.
+func hashMurmur(key string) uint32 {
+	const (
+		m = uint32(0x5bd1e995)
+		r = 24
+	)
+	n := len(key)
+	h := uint32(0xdeadbeef)*uint32(n) ^ uint32(n)
+	i := 0
+	for ; n-i >= 4; i += 4 {
+		k := uint32(key[i]) | uint32(key[i+1])<<8 | uint32(key[i+2])<<16 | uint32(key[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	switch n - i {
+	case 3:
+		h ^= uint32(key[i+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(key[i+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(key[i])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// ketamaHash returns alignment-th 4 bytes of md5 in little endian.
+func ketamaHash(key string, alignment int) uint32 {
+	sum := md5.Sum([]byte(key))
+	b := sum[alignment*4:]
+	return uint32(b[3])<<24 | uint32(b[2])<<16 | uint32(b[1])<<8 | uint32(b[0])
+}
+
+// ringTagKey returns the part of key between the two chars of tag,
+// the whole key if there is no such part.
+func ringTagKey(key, tag string) string {
+	if len(tag) != 2 {
+		return key
+	}
+	s := strings.IndexByte(key, tag[0])
+	if s < 0 {
+		return key
+	}
+	if e := strings.IndexByte(key[s+1:], tag[1]); e > 0 {
+		return key[s+1 : s+1+e]
+	}
+	return key
+}
+
+// ringKeyHash hashes the part of key in tag like server_pool_hash of
+// twemproxy, an empty one is 0 rather than hash of "".
+func ringKeyHash(hash ringHashFunc, key, tag string) uint32 {
+	key = ringTagKey(key, tag)
+	if key == "" {
+		return 0
+	}
+	return hash(key)
+}
+
+type ringPoint struct {
+	value uint32
+	index int // of shard
+}
+
+type ringPoints []ringPoint
+
+func (p ringPoints) Len() int           { return len(p) }
+func (p ringPoints) Less(i, j int) bool { return p[i].value < p[j].value }
+func (p ringPoints) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// ringContinuum maps hash of key to index of shard.
+type ringContinuum struct {
+	distribution string
+	points       ringPoints
+}
+
+// newRingContinuum builds the continuum over the live shards like
+// twemproxy ketama_update and modula_update do.
+func newRingContinuum(distribution string, shards []*ringShard, live []bool) *ringContinuum {
+	c := &ringContinuum{distribution: distribution}
+
+	var nlive, totalWeight int
+	for i, shard := range shards {
+		if live[i] {
+			nlive++
+			totalWeight += shard.Weight
+		}
+	}
+	if nlive == 0 {
+		return c
+	}
+
+	for i, shard := range shards {
+		if !live[i] {
+			continue
+		}
+		if distribution == RingModula {
+			for w := 0; w < shard.Weight; w++ {
+				c.points = append(c.points, ringPoint{index: i})
+			}
+			continue
+		}
+
+		// Float32 on purpose, it's what twemproxy computes with.
+		pct := float32(shard.Weight) / float32(totalWeight)
+		f := pct * ketamaPointsPerServer / ketamaPointsPerHash * float32(nlive)
+		ns := int(math.Floor(float64(float32(float64(f) + 0.0000000001))))
+		for p := 0; p < ns; p++ {
+			host := fmt.Sprintf("%s-%d", shard.Name, p)
+			for a := 0; a < ketamaPointsPerHash; a++ {
+				c.points = append(c.points, ringPoint{value: ketamaHash(host, a), index: i})
+			}
+		}
+	}
+	if distribution != RingModula {
+		sort.Sort(c.points)
+	}
+	return c
+}
+
+// Get returns index of shard for hash, -1 if no shard is live.
+func (c *ringContinuum) Get(hash uint32) int {
+	if len(c.points) == 0 {
+		return -1
+	}
+	if c.distribution == RingModula {
+		return c.points[hash%uint32(len(c.points))].index
+	}
+	i := sort.Search(len(c.points), func(i int) bool { return c.points[i].value >= hash })
+	if i == len(c.points) {
+		i = 0
+	}
+	return c.points[i].index
+}
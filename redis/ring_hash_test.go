@@ -0,0 +1,86 @@
+package redis
+
+import (
+	"testing"
+)
+
+// Vectors are computed by the C code of twemproxy hash functions, and
+// server_pool_hash with ketama_update/ketama_dispatch and
+// modula_update/modula_dispatch for this pool of nutcracker.yml:
+//
+//	servers:
+//	 - 10.0.0.1:6379:1 redis1
+//	 - 10.0.0.2:6379:1 redis2
+//	 - 10.0.0.3:6379:2 redis3
+//
+// A change failing them moves keys of pools migrated from twemproxy.
+var ringVectorShards = []*ringShard{
+	{RingShard: RingShard{Name: "redis1", Addr: "10.0.0.1:6379", Weight: 1}},
+	{RingShard: RingShard{Name: "redis2", Addr: "10.0.0.2:6379", Weight: 1}},
+	{RingShard: RingShard{Name: "redis3", Addr: "10.0.0.3:6379", Weight: 2}},
+}
+
+var ringVectorKeys = []string{
+	"", "a", "foo", "bar", "user:1000", "user:1001", "session:abcdef",
+	"counter", "中文", "key-with-a-rather-long-name-0123456789",
+}
+
+// TestRingHashVectors checks hash functions alone, twemproxy never
+// hashes "" to place it.
+func TestRingHashVectors(t *testing.T) {
+	tests := []struct {
+		hash   string
+		values []uint32
+	}{
+		{RingFnv1a64, []uint32{0x84222325, 0x8601ec8c, 0xfed9d577, 0x1339461a, 0xae7b4289, 0xae7b40d6, 0xcac322e0, 0x16517c63, 0xdbb4e6c5, 0xbb6e1b1b}},
+		{RingMd5, []uint32{0xd98c1dd4, 0xb975c10c, 0xdb18bdac, 0x191db537, 0x2e986207, 0xe4d46b12, 0x7706b6d8, 0x3bb76b88, 0x23c2baa7, 0x970708c3}},
+		{RingCrc32, []uint32{0x00000000, 0x000068b7, 0x00000c73, 0x000076ff, 0x00001f74, 0x00006873, 0x00007d6b, 0x00004122, 0x00005a09, 0x000022a5}},
+		{RingMurmur, []uint32{0x00000000, 0x4b41757c, 0xc4e0338f, 0x1ecb8583, 0x8e24aec7, 0x4ca47a0b, 0x97171306, 0x7d9171d6, 0xe240ad88, 0x61d38588}},
+	}
+	for _, tt := range tests {
+		hash, err := ringHash(tt.hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, key := range ringVectorKeys {
+			if v := hash(key); v != tt.values[i] {
+				t.Errorf("%s(%q) = %#08x, want %#08x", tt.hash, key, v, tt.values[i])
+			}
+		}
+	}
+}
+
+func TestRingContinuumVectors(t *testing.T) {
+	tests := []struct {
+		distribution string
+		hash         string
+		shards       []int
+	}{
+		// "" is placed by hash 0
+		{RingKetama, RingFnv1a64, []int{0, 2, 0, 1, 2, 2, 2, 1, 0, 2}},
+		{RingKetama, RingMd5, []int{0, 2, 0, 1, 2, 2, 2, 2, 2, 2}},
+		// 15 bits crc32 is below the first point of continuum
+		{RingKetama, RingCrc32, []int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{RingKetama, RingMurmur, []int{0, 0, 0, 2, 2, 0, 2, 2, 0, 2}},
+		{RingModula, RingFnv1a64, []int{0, 0, 2, 2, 1, 2, 0, 2, 1, 2}},
+		{RingModula, RingMd5, []int{0, 0, 0, 2, 2, 2, 0, 0, 2, 2}},
+		{RingModula, RingCrc32, []int{0, 2, 2, 2, 0, 2, 2, 2, 1, 1}},
+		{RingModula, RingMurmur, []int{0, 0, 2, 2, 2, 2, 2, 2, 0, 0}},
+	}
+	live := []bool{true, true, true}
+	for _, tt := range tests {
+		hash, err := ringHash(tt.hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := newRingContinuum(tt.distribution, ringVectorShards, live)
+		if tt.distribution == RingKetama && len(c.points) != 480 {
+			t.Fatalf("ketama continuum has %d points, want 480", len(c.points))
+		}
+		for i, key := range ringVectorKeys {
+			if shard := c.Get(ringKeyHash(hash, key, "")); shard != tt.shards[i] {
+				t.Errorf("%s %s: %q on shard %d, want %d", tt.distribution, tt.hash, key, shard, tt.shards[i])
+			}
+		}
+	}
+}
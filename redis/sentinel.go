@@ -3,9 +3,11 @@ package redis
 import (
 	"errors"
 	"log"
+	"math/rand"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -31,6 +33,12 @@ type FailoverOptions struct {
 	PoolSize    int
 	PoolTimeout time.Duration
 	IdleTimeout time.Duration
+
+	MaxRetries int
+
+	// Sends read only commands to slaves, falls back to master on
+	// error. Can be changed later by SetSlaveOk.
+	SlaveOk bool
 }
 
 func (opt *FailoverOptions) options() *Options {
@@ -47,12 +55,28 @@ func (opt *FailoverOptions) options() *Options {
 		PoolSize:    opt.PoolSize,
 		PoolTimeout: opt.PoolTimeout,
 		IdleTimeout: opt.IdleTimeout,
+
+		MaxRetries: opt.MaxRetries,
 	}
 }
 
+// FailoverClient is a Redis client of the master found by sentinels,
+// read only commands may go to slaves of the master.
+type FailoverClient struct {
+	*Client
+	commandable
+
+	failover *sentinelFailover
+	slaveOk  int32 // atomic
+
+	slavesMx sync.RWMutex
+	slaves   map[string]*Client
+	onChange func()
+}
+
 // NewFailoverClient returns a Redis client with automatic failover
 // capabilities using Redis Sentinel.
-func NewFailoverClient(failoverOpt *FailoverOptions) *Client {
+func NewFailoverClient(failoverOpt *FailoverOptions) *FailoverClient {
 	opt := failoverOpt.options()
 	failover := &sentinelFailover{
 		masterName:    failoverOpt.MasterName,
@@ -60,7 +84,166 @@ func NewFailoverClient(failoverOpt *FailoverOptions) *Client {
 
 		opt: opt,
 	}
-	return newClient(opt, failover.Pool())
+	c := &FailoverClient{
+		Client:   newClient(opt, failover.Pool()),
+		failover: failover,
+		slaves:   make(map[string]*Client),
+	}
+	c.commandable.process = c.process
+	c.SetSlaveOk(failoverOpt.SlaveOk)
+	failover.onSwitch = c.switched
+	// Find master and slaves now, later on +switch-master.
+	failover.MasterAddr()
+	c.reloadSlaves()
+	return c
+}
+
+// SetOptions changes retries and timeouts at runtime, of master, slaves
+// and sentinels connected from now on. Other options are ignored.
+func (c *FailoverClient) SetOptions(opt *FailoverOptions) {
+	t := opt.options().newTimeouts()
+	c.failover.opt.setTimeouts(t)
+
+	c.slavesMx.RLock()
+	for _, slave := range c.slaves {
+		slave.opt.setTimeouts(t)
+	}
+	c.slavesMx.RUnlock()
+}
+
+// MasterAddr returns address of current master.
+func (c *FailoverClient) MasterAddr() string {
+	return c.failover.Master()
+}
+
+// SlaveAddrs returns addresses of slaves in use.
+func (c *FailoverClient) SlaveAddrs() []string {
+	c.slavesMx.RLock()
+	addrs := make([]string, 0, len(c.slaves))
+	for addr := range c.slaves {
+		addrs = append(addrs, addr)
+	}
+	c.slavesMx.RUnlock()
+	return addrs
+}
+
+// PoolStats returns connections of master and slaves.
+func (c *FailoverClient) PoolStats() map[string]PoolStats {
+	stats := map[string]PoolStats{c.MasterAddr(): c.Client.PoolStats()}
+	c.slavesMx.RLock()
+	for addr, client := range c.slaves {
+		stats[addr] = client.PoolStats()
+	}
+	c.slavesMx.RUnlock()
+	return stats
+}
+
+// SetSlaveOk enables or disables reading from slaves.
+func (c *FailoverClient) SetSlaveOk(ok bool) {
+	if ok {
+		atomic.StoreInt32(&c.slaveOk, 1)
+	} else {
+		atomic.StoreInt32(&c.slaveOk, 0)
+	}
+}
+
+func (c *FailoverClient) SlaveOk() bool {
+	return atomic.LoadInt32(&c.slaveOk) == 1
+}
+
+func (c *FailoverClient) process(cmd Cmder) {
+	if c.SlaveOk() && isReadOnlyCmd(cmd) {
+		if slave := c.randomSlave(); slave != nil {
+			slave.Process(cmd)
+			// Reply errors like WRONGTYPE are the same on master, but
+			// not LOADING or MASTERDOWN of a syncing slave.
+			err := cmd.Err()
+			if !isNodeError(err) && !isSlaveNotReadyError(err) {
+				return
+			}
+			log.Printf("redis-sentinel: slave %s failed: %s, fallback to master", slave.opt.Addr, err)
+			cmd.reset()
+		}
+	}
+	c.Client.Process(cmd)
+}
+
+func (c *FailoverClient) randomSlave() *Client {
+	c.slavesMx.RLock()
+	defer c.slavesMx.RUnlock()
+	if len(c.slaves) == 0 {
+		return nil
+	}
+	n := rand.Intn(len(c.slaves))
+	for _, slave := range c.slaves {
+		if n == 0 {
+			return slave
+		}
+		n--
+	}
+	return nil
+}
+
+// OnChange sets fn to be called after master or slaves changed.
+func (c *FailoverClient) OnChange(fn func()) {
+	c.slavesMx.Lock()
+	c.onChange = fn
+	c.slavesMx.Unlock()
+}
+
+func (c *FailoverClient) switched() {
+	c.reloadSlaves()
+
+	c.slavesMx.RLock()
+	onChange := c.onChange
+	c.slavesMx.RUnlock()
+	if onChange != nil {
+		onChange()
+	}
+}
+
+// reloadSlaves asks sentinel for healthy slaves of the master, clients
+// of slaves gone are closed.
+func (c *FailoverClient) reloadSlaves() {
+	addrs, err := c.failover.SlaveAddrs()
+	if err != nil {
+		log.Printf("redis-sentinel: Slaves %q failed: %s", c.failover.masterName, err)
+		return
+	}
+
+	c.slavesMx.Lock()
+	defer c.slavesMx.Unlock()
+
+	seen := make(map[string]struct{}, len(addrs))
+	for _, addr := range addrs {
+		seen[addr] = struct{}{}
+		if _, ok := c.slaves[addr]; ok {
+			continue
+		}
+		opt := c.failover.opt.clone()
+		opt.Addr = addr
+		opt.Dialer = nil
+		c.slaves[addr] = NewClient(opt)
+		log.Printf("redis-sentinel: %q slave %s added", c.failover.masterName, addr)
+	}
+	for addr, slave := range c.slaves {
+		if _, ok := seen[addr]; !ok {
+			slave.Close()
+			delete(c.slaves, addr)
+			log.Printf("redis-sentinel: %q slave %s removed", c.failover.masterName, addr)
+		}
+	}
+}
+
+// Close closes the master and slave clients.
+func (c *FailoverClient) Close() error {
+	c.slavesMx.Lock()
+	for addr, slave := range c.slaves {
+		slave.Close()
+		delete(c.slaves, addr)
+	}
+	c.slavesMx.Unlock()
+	return c.Client.Close()
 }
 
 //------------------------------------------------------------------------------
@@ -102,6 +285,12 @@ func (c *sentinelClient) Sentinels(name string) *SliceCmd {
 	return cmd
 }
 
+func (c *sentinelClient) Slaves(name string) *SliceCmd {
+	cmd := NewSliceCmd("SENTINEL", "slaves", name)
+	c.Process(cmd)
+	return cmd
+}
+
 type sentinelFailover struct {
 	masterName    string
 	sentinelAddrs []string
@@ -111,8 +300,63 @@ type sentinelFailover struct {
 	pool     pool
 	poolOnce sync.Once
 
-	lock      sync.RWMutex
-	_sentinel *sentinelClient
+	lock       sync.RWMutex
+	_sentinel  *sentinelClient
+	masterAddr string
+
+	// Called after +switch-master or slaves of master changed.
+	onSwitch func()
+}
+
+// Master returns last known master address.
+func (d *sentinelFailover) Master() string {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.masterAddr
+}
+
+// SlaveAddrs returns addresses of slaves which are not down.
+func (d *sentinelFailover) SlaveAddrs() ([]string, error) {
+	d.lock.RLock()
+	sentinel := d._sentinel
+	d.lock.RUnlock()
+	if sentinel == nil {
+		return nil, errors.New("redis: all sentinels are unreachable")
+	}
+
+	slaves, err := sentinel.Slaves(d.masterName).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(slaves))
+	for _, slave := range slaves {
+		vals, ok := slave.([]interface{})
+		if !ok {
+			continue
+		}
+		var ip, port, flags string
+		for i := 0; i+1 < len(vals); i += 2 {
+			key, _ := vals[i].(string)
+			val, _ := vals[i+1].(string)
+			switch key {
+			case "ip":
+				ip = val
+			case "port":
+				port = val
+			case "flags":
+				flags = val
+			}
+		}
+		if ip == "" || port == "" ||
+			strings.Contains(flags, "s_down") ||
+			strings.Contains(flags, "o_down") ||
+			strings.Contains(flags, "disconnected") {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(ip, port))
+	}
+	return addrs, nil
 }
 
 func (d *sentinelFailover) dial() (net.Conn, error) {
@@ -120,7 +364,7 @@ func (d *sentinelFailover) dial() (net.Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	return net.DialTimeout("tcp", addr, d.opt.DialTimeout)
+	return net.DialTimeout("tcp", addr, d.opt.getDialTimeout())
 }
 
 func (d *sentinelFailover) Pool() pool {
@@ -144,6 +388,7 @@ func (d *sentinelFailover) MasterAddr() (string, error) {
 		} else {
 			addr := net.JoinHostPort(addr[0], addr[1])
 			log.Printf("redis-sentinel: %q addr is %s", d.masterName, addr)
+			d.masterAddr = addr
 			return addr, nil
 		}
 	}
@@ -152,13 +397,13 @@ func (d *sentinelFailover) MasterAddr() (string, error) {
 		sentinel := newSentinel(&Options{
 			Addr: sentinelAddr,
 
-			DialTimeout:  d.opt.DialTimeout,
-			ReadTimeout:  d.opt.ReadTimeout,
-			WriteTimeout: d.opt.WriteTimeout,
+			DialTimeout:  d.opt.getDialTimeout(),
+			ReadTimeout:  d.opt.getReadTimeout(),
+			WriteTimeout: d.opt.getWriteTimeout(),
 
 			PoolSize:    d.opt.PoolSize,
-			PoolTimeout: d.opt.PoolTimeout,
-			IdleTimeout: d.opt.IdleTimeout,
+			PoolTimeout: d.opt.getPoolTimeout(),
+			IdleTimeout: d.opt.getIdleTimeout(),
 		})
 		masterAddr, err := sentinel.GetMasterAddrByName(d.masterName).Result()
 		if err != nil {
@@ -173,6 +418,7 @@ func (d *sentinelFailover) MasterAddr() (string, error) {
 		d.setSentinel(sentinel)
 		addr := net.JoinHostPort(masterAddr[0], masterAddr[1])
 		log.Printf("redis-sentinel: %q addr is %s", d.masterName, addr)
+		d.masterAddr = addr
 		return addr, nil
 	}
 
@@ -242,7 +488,7 @@ func (d *sentinelFailover) listen() {
 	for {
 		if pubsub == nil {
 			pubsub = d._sentinel.PubSub()
-			if err := pubsub.Subscribe("+switch-master"); err != nil {
+			if err := pubsub.Subscribe("+switch-master", "+slave", "+sdown", "-sdown"); err != nil {
 				log.Printf("redis-sentinel: Subscribe failed: %s", err)
 				d.lock.Lock()
 				d.resetSentinel()
@@ -273,7 +519,23 @@ func (d *sentinelFailover) listen() {
 					d.masterName, addr,
 				)
 
+				d.lock.Lock()
+				d.masterAddr = addr
+				d.lock.Unlock()
+
 				d.closeOldConns(addr)
+				if d.onSwitch != nil {
+					d.onSwitch()
+				}
+			case "+slave", "+sdown", "-sdown":
+				// <type> <name> <ip> <port> @ <master-name> <master-ip> <master-port>
+				parts := strings.Split(msg.Payload, " ")
+				if len(parts) < 6 || parts[0] != "slave" || parts[5] != d.masterName {
+					continue
+				}
+				if d.onSwitch != nil {
+					d.onSwitch()
+				}
 			default:
 				log.Printf("redis-sentinel: unsupported message: %s", msg)
 			}
@@ -0,0 +1,312 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer speaks just enough RESP to stand in for redis and sentinel.
+type fakeServer struct {
+	l       net.Listener
+	handler func(c net.Conn, args []string) string
+
+	mx    sync.Mutex
+	conns []net.Conn
+}
+
+func newFakeServer(t *testing.T, handler func(c net.Conn, args []string) string) *fakeServer {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{l: l, handler: handler}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) Addr() string {
+	return s.l.Addr().String()
+}
+
+func (s *fakeServer) Close() {
+	s.l.Close()
+	s.mx.Lock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.mx.Unlock()
+}
+
+func (s *fakeServer) serve() {
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.mx.Lock()
+		s.conns = append(s.conns, c)
+		s.mx.Unlock()
+		go s.serveConn(c)
+	}
+}
+
+func (s *fakeServer) serveConn(c net.Conn) {
+	defer c.Close()
+	rd := bufio.NewReader(c)
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil || len(line) < 3 || line[0] != '*' {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			if _, err := rd.ReadString('\n'); err != nil {
+				return
+			}
+			arg, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			args[i] = strings.TrimSpace(arg)
+		}
+		if reply := s.handler(c, args); reply != "" {
+			c.Write([]byte(reply))
+		}
+	}
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func multiBulk(vals ...string) string {
+	reply := fmt.Sprintf("*%d\r\n", len(vals))
+	for _, v := range vals {
+		reply += bulk(v)
+	}
+	return reply
+}
+
+// fakeNode replies GET with its name, so tests see who served it.
+func fakeNode(t *testing.T, name string) *fakeServer {
+	return newFakeServer(t, func(c net.Conn, args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "GET":
+			return bulk(name)
+		case "PING":
+			return "+PONG\r\n"
+		}
+		return "+OK\r\n"
+	})
+}
+
+// fakeSentinel knows one master and its slaves and can publish
+// +switch-master to subscribers.
+type fakeSentinel struct {
+	*fakeServer
+
+	mx     sync.Mutex
+	master string
+	slaves map[string]string // addr => flags
+	subs   []net.Conn
+}
+
+func newFakeSentinel(t *testing.T, master string) *fakeSentinel {
+	s := &fakeSentinel{master: master, slaves: make(map[string]string)}
+	s.fakeServer = newFakeServer(t, s.handle)
+	return s
+}
+
+func (s *fakeSentinel) handle(c net.Conn, args []string) string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "SUBSCRIBE":
+		s.subs = append(s.subs, c)
+		reply := ""
+		for i, ch := range args[1:] {
+			reply += fmt.Sprintf("*3\r\n%s%s:%d\r\n", bulk("subscribe"), bulk(ch), i+1)
+		}
+		return reply
+	case "SENTINEL":
+		switch strings.ToLower(args[1]) {
+		case "get-master-addr-by-name":
+			host, port, _ := net.SplitHostPort(s.master)
+			return multiBulk(host, port)
+		case "sentinels":
+			return "*0\r\n"
+		case "slaves":
+			reply := fmt.Sprintf("*%d\r\n", len(s.slaves))
+			for addr, flags := range s.slaves {
+				host, port, _ := net.SplitHostPort(addr)
+				reply += multiBulk("name", addr, "ip", host, "port", port, "flags", flags)
+			}
+			return reply
+		}
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func (s *fakeSentinel) switchMaster(name, addr string) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	oldHost, oldPort, _ := net.SplitHostPort(s.master)
+	host, port, _ := net.SplitHostPort(addr)
+	s.master = addr
+	payload := strings.Join([]string{name, oldHost, oldPort, host, port}, " ")
+	msg := multiBulk("message", "+switch-master", payload)
+	for _, c := range s.subs {
+		c.Write([]byte(msg))
+	}
+}
+
+func waitFor(t *testing.T, what string, f func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFailoverClientFollowsSwitchMaster(t *testing.T) {
+	master1 := fakeNode(t, "master1")
+	defer master1.Close()
+	master2 := fakeNode(t, "master2")
+	defer master2.Close()
+	sentinel := newFakeSentinel(t, master1.Addr())
+	defer sentinel.Close()
+
+	client := NewFailoverClient(&FailoverOptions{
+		MasterName:    "mymaster",
+		SentinelAddrs: []string{sentinel.Addr()},
+	})
+	defer client.Close()
+
+	get := func() string {
+		return client.OnGET(NewRequest([]string{"GET", "foo"})).Val()
+	}
+	if v := get(); v != "master1" {
+		t.Fatalf("GET served by %q, want master1", v)
+	}
+	if addr := client.MasterAddr(); addr != master1.Addr() {
+		t.Fatalf("MasterAddr is %q, want %q", addr, master1.Addr())
+	}
+
+	sentinel.switchMaster("mymaster", master2.Addr())
+	waitFor(t, "master switch", func() bool {
+		return client.MasterAddr() == master2.Addr()
+	})
+	waitFor(t, "GET served by master2", func() bool {
+		return get() == "master2"
+	})
+}
+
+func TestFailoverClientReadsFromSlaves(t *testing.T) {
+	master := fakeNode(t, "master")
+	defer master.Close()
+	slave := fakeNode(t, "slave")
+	defer slave.Close()
+	down := fakeNode(t, "down")
+	defer down.Close()
+	sentinel := newFakeSentinel(t, master.Addr())
+	sentinel.slaves[slave.Addr()] = "slave"
+	sentinel.slaves[down.Addr()] = "slave,s_down"
+	defer sentinel.Close()
+
+	client := NewFailoverClient(&FailoverOptions{
+		MasterName:    "mymaster",
+		SentinelAddrs: []string{sentinel.Addr()},
+	})
+	defer client.Close()
+
+	if addrs := client.SlaveAddrs(); len(addrs) != 1 || addrs[0] != slave.Addr() {
+		t.Fatalf("SlaveAddrs is %v, want [%s]", addrs, slave.Addr())
+	}
+
+	get := NewRequest([]string{"GET", "foo"})
+	if v := client.OnGET(get).Val(); v != "master" {
+		t.Fatalf("GET without slaveok served by %q, want master", v)
+	}
+
+	client.SetSlaveOk(true)
+	for i := 0; i < 10; i++ {
+		if v := client.OnGET(get).Val(); v != "slave" {
+			t.Fatalf("GET with slaveok served by %q, want slave", v)
+		}
+	}
+
+	// Slave gone, reads fall back to master.
+	slave.Close()
+	if v := client.OnGET(get).Val(); v != "master" {
+		t.Fatalf("GET with broken slave served by %q, want master", v)
+	}
+}
+
+func TestFailoverClientSlaveReplyError(t *testing.T) {
+	master := fakeNode(t, "master")
+	defer master.Close()
+	slave := newFakeServer(t, func(c net.Conn, args []string) string {
+		return "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+	})
+	defer slave.Close()
+	sentinel := newFakeSentinel(t, master.Addr())
+	sentinel.slaves[slave.Addr()] = "slave"
+	defer sentinel.Close()
+
+	client := NewFailoverClient(&FailoverOptions{
+		MasterName:    "mymaster",
+		SentinelAddrs: []string{sentinel.Addr()},
+	})
+	defer client.Close()
+	client.SetSlaveOk(true)
+
+	cmd := client.OnGET(NewRequest([]string{"GET", "foo"}))
+	if err := cmd.Err(); err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		t.Fatalf("GET with slave reply error got %q, %v, want WRONGTYPE", cmd.Val(), err)
+	}
+}
+
+func TestFailoverClientSlaveNotReady(t *testing.T) {
+	master := fakeNode(t, "master")
+	defer master.Close()
+	// slave replies GET with the error of its key
+	slave := newFakeServer(t, func(c net.Conn, args []string) string {
+		if strings.ToUpper(args[0]) == "GET" {
+			return "-" + args[1] + "\r\n"
+		}
+		return "+OK\r\n"
+	})
+	defer slave.Close()
+	sentinel := newFakeSentinel(t, master.Addr())
+	sentinel.slaves[slave.Addr()] = "slave"
+	defer sentinel.Close()
+
+	client := NewFailoverClient(&FailoverOptions{
+		MasterName:    "mymaster",
+		SentinelAddrs: []string{sentinel.Addr()},
+	})
+	defer client.Close()
+	client.SetSlaveOk(true)
+
+	for _, key := range []string{
+		"LOADING Redis is loading the dataset in memory",
+		"MASTERDOWN Link with MASTER is down",
+		"TRYAGAIN Multiple keys request during rehashing of slot",
+	} {
+		cmd := client.OnGET(NewRequest([]string{"GET", key}))
+		if v, err := cmd.Result(); err != nil || v != "master" {
+			t.Errorf("GET with slave reply %q got %q, %v, want master", key, v, err)
+		}
+	}
+}
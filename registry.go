@@ -0,0 +1,138 @@
+package smartproxy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/ngaut/logging"
+)
+
+const (
+	RegistryZk   = "zk"
+	RegistryFile = "file"
+
+	// registration is checked and renewed this often
+	registryHeartbeat = 10 * time.Second
+)
+
+// ProxyNode is a proxy registered for clients
+type ProxyNode struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Addr string `json:"addr"` // ip clients connect to
+	Port string `json:"port"`
+}
+
+// Registry publishes this proxy and finds all proxies of the product
+type Registry interface {
+	// Register publishes node until Deregister or Close
+	Register(node ProxyNode) error
+	Deregister() error
+	// Heartbeat registers node again if registration was lost
+	Heartbeat() error
+	// Watch calls fn with all proxies now and after every change
+	Watch(fn func([]ProxyNode))
+	Close() error
+}
+
+// NewRegistry returns registry of config, nil if not configured
+func NewRegistry(c *ProxyConfig) (Registry, error) {
+	switch c.RegistryType {
+	case "":
+		return nil, nil
+	case RegistryZk:
+		return newZkRegistry(strings.Split(c.Zk, ","), c.ZkPath)
+	case RegistryFile:
+		return newFileRegistry(c.RegistryPath), nil
+	}
+	return nil, fmt.Errorf("unknown registry type %s", c.RegistryType)
+}
+
+// ServeRegistry registers proxy and keeps it registered until quit, it
+// is deregistered earlier by Drain
+func (ps *ProxyServer) ServeRegistry() {
+	if ps.Registry == nil {
+		return
+	}
+
+	addr := ps.Conf.RegistryAddr
+	if addr == "" {
+		addr = localIP()
+	}
+	node := ProxyNode{Id: ps.Conf.Id, Name: ps.Conf.Name, Addr: addr, Port: ps.Conf.Port}
+	// heartbeat retries
+	if err := ps.Registry.Register(node); err != nil {
+		log.Warning("register proxy failed ", err)
+	}
+	ps.Registry.Watch(ps.setProxies)
+
+	ticker := time.NewTicker(registryHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if ps.Draining() {
+				continue
+			}
+			if err := ps.Registry.Heartbeat(); err != nil {
+				log.Warning("registry heartbeat failed ", err)
+			}
+		case <-ps.Quit:
+			goto quit
+		}
+	}
+quit:
+	if err := ps.Registry.Deregister(); err != nil {
+		log.Warning("deregister proxy failed ", err)
+	}
+	ps.Registry.Close()
+	log.Warning("quit ServeRegistry...")
+}
+
+func (ps *ProxyServer) setProxies(nodes []ProxyNode) {
+	log.Infof("registry has %d proxies", len(nodes))
+	ps.Lock.Lock()
+	ps.proxies = nodes
+	ps.Lock.Unlock()
+}
+
+// Proxies returns proxies found by registry, nil if not configured
+func (ps *ProxyServer) Proxies() []ProxyNode {
+	ps.Lock.Lock()
+	defer ps.Lock.Unlock()
+	return append([]ProxyNode(nil), ps.proxies...)
+}
+
+// byProxyId sorts ProxyNode by id then addr
+type byProxyId []ProxyNode
+
+func (s byProxyId) Len() int      { return len(s) }
+func (s byProxyId) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byProxyId) Less(i, j int) bool {
+	if s[i].Id != s[j].Id {
+		return s[i].Id < s[j].Id
+	}
+	return s[i].Addr+":"+s[i].Port < s[j].Addr+":"+s[j].Port
+}
+
+func sortProxies(nodes []ProxyNode) {
+	sort.Sort(byProxyId(nodes))
+}
+
+// localIP returns first non loopback ipv4 addr
+func localIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Warning("get interface addrs failed ", err)
+		return "127.0.0.1"
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+			return ipnet.IP.String()
+		}
+	}
+	return "127.0.0.1"
+}
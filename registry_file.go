@@ -0,0 +1,135 @@
+package smartproxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/ngaut/logging"
+)
+
+// files not renewed in it are of dead proxies
+const fileRegistryTTL = 3 * registryHeartbeat
+
+// fileRegistry keeps proxies as json files in a local dir, it is for
+// testing without zk. Every process has its own file, renewed by
+// Heartbeat.
+type fileRegistry struct {
+	dir string
+
+	lock sync.Mutex
+	node *ProxyNode // nil if not registered
+	path string
+
+	quit chan struct{}
+	once sync.Once
+}
+
+func newFileRegistry(dir string) *fileRegistry {
+	return &fileRegistry{dir: dir, quit: make(chan struct{})}
+}
+
+func (r *fileRegistry) Register(node ProxyNode) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.node = &node
+	r.path = filepath.Join(r.dir, node.Id+"-"+strconv.Itoa(os.Getpid())+".json")
+	log.Info("register proxy to file ", r.path)
+	return r.write()
+}
+
+// write replaces file at once, r.lock must be held
+func (r *fileRegistry) write() error {
+	data, err := json.Marshal(r.node)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+func (r *fileRegistry) Deregister() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.node == nil {
+		return nil
+	}
+	r.node = nil
+	log.Warning("deregister proxy from file ", r.path)
+	err := os.Remove(r.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (r *fileRegistry) Heartbeat() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.node == nil {
+		return nil
+	}
+	return r.write()
+}
+
+func (r *fileRegistry) Watch(fn func([]ProxyNode)) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		var last []ProxyNode
+		for {
+			nodes := r.nodes()
+			if last == nil || !reflect.DeepEqual(nodes, last) {
+				fn(nodes)
+				last = nodes
+			}
+			select {
+			case <-ticker.C:
+			case <-r.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (r *fileRegistry) nodes() []ProxyNode {
+	nodes := make([]ProxyNode, 0)
+	files, err := ioutil.ReadDir(r.dir)
+	if err != nil {
+		return nodes
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") || time.Since(f.ModTime()) > fileRegistryTTL {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(r.dir, f.Name()))
+		if err != nil {
+			continue
+		}
+		var node ProxyNode
+		if err := json.Unmarshal(data, &node); err != nil {
+			log.Warningf("bad registry file %s %s", f.Name(), err)
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	sortProxies(nodes)
+	return nodes
+}
+
+func (r *fileRegistry) Close() error {
+	r.once.Do(func() { close(r.quit) })
+	return nil
+}
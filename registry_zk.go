@@ -0,0 +1,166 @@
+package smartproxy
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/ngaut/logging"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const zkSessionTimeout = 10 * time.Second
+
+// zkRegistry registers proxy as ephemeral sequential node under root,
+// zk deletes it if proxy dies. Nodes are sequential so a new process of
+// Upgrade never shares node with the old one.
+type zkRegistry struct {
+	conn *zk.Conn
+	root string
+
+	lock sync.Mutex
+	node *ProxyNode // nil if not registered
+	path string     // created node, empty if lost
+
+	quit chan struct{}
+	once sync.Once
+}
+
+func newZkRegistry(servers []string, root string) (*zkRegistry, error) {
+	conn, events, err := zk.Connect(servers, zkSessionTimeout)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for e := range events {
+			if e.Type == zk.EventSession {
+				log.Info("zk session ", e.State)
+			}
+		}
+	}()
+	r := &zkRegistry{
+		conn: conn,
+		root: "/" + strings.Trim(root, "/"),
+		quit: make(chan struct{}),
+	}
+	return r, nil
+}
+
+func (r *zkRegistry) Register(node ProxyNode) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.node = &node
+	return r.register()
+}
+
+// register creates node, r.lock must be held
+func (r *zkRegistry) register() error {
+	data, err := json.Marshal(r.node)
+	if err != nil {
+		return err
+	}
+	if err := r.mkdirs(); err != nil {
+		return err
+	}
+	path, err := r.conn.Create(r.root+"/"+r.node.Id+"-", data, zk.FlagEphemeral|zk.FlagSequence, zk.WorldACL(zk.PermAll))
+	if err != nil {
+		return err
+	}
+	r.path = path
+	log.Info("register proxy to zk ", path)
+	return nil
+}
+
+// mkdirs creates persistent parents of root
+func (r *zkRegistry) mkdirs() error {
+	path := ""
+	for _, name := range strings.Split(strings.Trim(r.root, "/"), "/") {
+		path += "/" + name
+		_, err := r.conn.Create(path, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *zkRegistry) Deregister() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	path := r.path
+	r.node, r.path = nil, ""
+	if path == "" {
+		return nil
+	}
+	log.Warning("deregister proxy from zk ", path)
+	err := r.conn.Delete(path, -1)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	return err
+}
+
+func (r *zkRegistry) Heartbeat() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.node == nil {
+		return nil
+	}
+	if r.path != "" {
+		ok, _, err := r.conn.Exists(r.path)
+		if err != nil || ok {
+			return err
+		}
+		// session expired, zk deleted node
+		log.Warning("zk node lost ", r.path)
+		r.path = ""
+	}
+	return r.register()
+}
+
+func (r *zkRegistry) Watch(fn func([]ProxyNode)) {
+	go func() {
+		for {
+			children, _, ch, err := r.conn.ChildrenW(r.root)
+			if err == nil {
+				fn(r.nodes(children))
+			} else {
+				// root may not exist yet
+				log.Warning("watch zk failed ", err)
+				ch = nil
+			}
+			select {
+			case <-ch:
+			case <-time.After(registryHeartbeat):
+			case <-r.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (r *zkRegistry) nodes(children []string) []ProxyNode {
+	nodes := make([]ProxyNode, 0, len(children))
+	for _, child := range children {
+		data, _, err := r.conn.Get(r.root + "/" + child)
+		if err != nil {
+			// gone after listed
+			continue
+		}
+		var node ProxyNode
+		if err := json.Unmarshal(data, &node); err != nil {
+			log.Warningf("bad zk node %s %s", child, err)
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	sortProxies(nodes)
+	return nodes
+}
+
+func (r *zkRegistry) Close() error {
+	r.once.Do(func() { close(r.quit) })
+	r.conn.Close()
+	return nil
+}
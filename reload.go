@@ -0,0 +1,179 @@
+package smartproxy
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+
+	log "github.com/ngaut/logging"
+)
+
+// reloadOptions are ProxyConfig fields applied by ReloadConfig, value is
+// name of PROXY CONFIG SET applying it, empty if applied by ReloadConfig
+// itself. Changes of other fields need restart.
+var reloadOptions = map[string]string{
+	"LogLevel":      "loglevel",
+	"IdleTime":      "idletime",
+	"MaxConn":       "maxconn",
+	"MulOpParallel": "mulparallel",
+	"SlaveOk":       "slaveok",
+	"Statsd":        "statsd",
+	"DialTimeout":   "dialtimeout",
+	"ReadTimeout":   "readtimeout",
+	"WriteTimeout":  "writetimeout",
+	"PoolTimeout":   "pooltimeout",
+	"IdleTimeout":   "idletimeout",
+	"MaxRedirects":  "maxredirects",
+	"MaxRetries":    "maxretries",
+	"SlowTimeout":   "slowtimeout",
+	"SlowCommands":  "",
+	"GraceTime":     "",
+	"StatsdSample":  "",
+	"Cpus":          "",
+	"Nodes":         "",
+	"BlackKeys":     "",
+
+	"DenyCommands":   "",
+	"AllowCommands":  "",
+	"RenameCommands": "",
+}
+
+// commandOptions are fields of [commands] applied by ReloadCommands
+var commandOptions = map[string]bool{
+	"DenyCommands":   true,
+	"AllowCommands":  true,
+	"RenameCommands": true,
+}
+
+// reloadIgnored are not compared, Secondary is compared by migrateTarget
+var reloadIgnored = map[string]bool{
+	"FileName":  true,
+	"Config":    true,
+	"Secondary": true,
+}
+
+// ReloadConfig reads config file again, changed options which are safe
+// at runtime are applied, others are logged and ignored until restart.
+// It returns one line for every changed option.
+func (ps *ProxyServer) ReloadConfig() ([]string, error) {
+	return ps.reload(nil)
+}
+
+// ReloadCommands reads config file again like ReloadConfig, but applies
+// [commands] only
+func (ps *ProxyServer) ReloadCommands() ([]string, error) {
+	return ps.reload(commandOptions)
+}
+
+// reload applies fields of only, all fields if nil
+func (ps *ProxyServer) reload(only map[string]bool) ([]string, error) {
+	ps.confLock.Lock()
+	defer ps.confLock.Unlock()
+
+	pc, err := LoadProxyConfig(ps.Conf.FileName)
+	if err != nil {
+		log.Warning("reload config failed ", err)
+		return nil, err
+	}
+
+	changes := make([]string, 0)
+	oldv := reflect.ValueOf(ps.Conf).Elem()
+	newv := reflect.ValueOf(pc).Elem()
+	for i := 0; i < oldv.NumField(); i++ {
+		field := oldv.Type().Field(i).Name
+		old, value := oldv.Field(i).Interface(), newv.Field(i).Interface()
+		if reloadIgnored[field] || (only != nil && !only[field]) || reflect.DeepEqual(old, value) {
+			continue
+		}
+
+		var change string
+		name, ok := reloadOptions[field]
+		switch {
+		case !ok:
+			change = fmt.Sprintf("%s: need restart, ignored", field)
+		case name == "":
+			ps.reloadField(field, pc)
+			change = fmt.Sprintf("%s: %v -> %v", field, old, value)
+		default:
+			if _, err := ps.configSet(name, reloadValue(value)); err != nil {
+				change = fmt.Sprintf("%s: rejected, %s", field, err)
+			} else {
+				change = fmt.Sprintf("%s: %v -> %v", field, old, value)
+			}
+		}
+		log.Warning("reload config ", change)
+		changes = append(changes, change)
+	}
+
+	if only == nil && !reflect.DeepEqual(migrateTarget(ps.Conf.Secondary), migrateTarget(pc.Secondary)) {
+		change := "Secondary: need restart, ignored"
+		log.Warning("reload config ", change)
+		changes = append(changes, change)
+	}
+
+	// later saves must keep what is in file now
+	ps.Conf.Config = pc.Config
+	log.Warningf("reload config %s, %d changes", ps.Conf.FileName, len(changes))
+	return changes, nil
+}
+
+// reloadField applies option of reloadOptions without PROXY CONFIG SET
+func (ps *ProxyServer) reloadField(field string, pc *ProxyConfig) {
+	switch field {
+	case "SlowCommands":
+		ps.Conf.SlowCommands = pc.SlowCommands
+		ps.setBackendOptions()
+	case "GraceTime":
+		ps.Conf.GraceTime = pc.GraceTime
+	case "StatsdSample":
+		ps.Conf.StatsdSample = pc.StatsdSample
+	case "Cpus":
+		ps.Conf.Cpus = pc.Cpus
+		runtime.GOMAXPROCS(pc.Cpus)
+	case "Nodes":
+		// cluster finds other nodes by itself, removed seeds are kept
+		ps.Conf.Nodes = pc.Nodes
+		if b, ok := ps.Backend.(*clusterBackend); ok {
+			b.AddAddrs(pc.Nodes)
+		}
+	case "BlackKeys":
+		ps.Blacklist.SetConfig(pc.BlackKeys)
+		ps.Conf.BlackKeys = pc.BlackKeys
+	case "DenyCommands", "AllowCommands", "RenameCommands":
+		switch field {
+		case "DenyCommands":
+			ps.Conf.DenyCommands = pc.DenyCommands
+		case "AllowCommands":
+			ps.Conf.AllowCommands = pc.AllowCommands
+		default:
+			ps.Conf.RenameCommands = pc.RenameCommands
+		}
+		// checked together by LoadProxyConfig, so built from pc
+		if err := ps.setCommands(pc); err != nil {
+			log.Warning("reload commands failed ", err)
+		}
+	}
+}
+
+// reloadValue formats v as PROXY CONFIG SET value
+func reloadValue(v interface{}) string {
+	if b, ok := v.(bool); ok {
+		if b {
+			return "1"
+		}
+		return "0"
+	}
+	return fmt.Sprint(v)
+}
+
+// migrateTarget returns backend options of secondary, others are copied
+// from primary
+func migrateTarget(pc *ProxyConfig) []interface{} {
+	if pc == nil {
+		return nil
+	}
+	return []interface{}{
+		pc.BackendType, pc.Nodes, pc.Addr, pc.MasterName, pc.Sentinels,
+		pc.Servers, pc.Distribution, pc.Hash, pc.HashTag,
+	}
+}
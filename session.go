@@ -16,20 +16,35 @@ func HandleConn(ps *ProxyServer, c net.Conn) {
 	// log.Info("start process Session, receive remote host ", addr)
 
 	s := NewSession(ps, c)
-	if int64(len(ps.SessMgr)) > ps.Conf.MaxConn {
+	if int64(ps.SessionCount()) > ps.Conf.MaxConn {
 		log.Warning("reached max connection, close ", addr)
 		s.Close()
 		return
 	}
 
+	ps.Lock.Lock()
 	ps.SessMgr[addr] = s
-	defer delete(ps.SessMgr, addr)
+	ps.Lock.Unlock()
+	defer func() {
+		ps.Lock.Lock()
+		delete(ps.SessMgr, addr)
+		ps.Lock.Unlock()
+	}()
 
 	for {
+		atomic.StoreInt32(&s.state, sessionIdle)
+		// finish requests already read, then leave
+		if ps.Draining() && s.r.Buffered() == 0 && s.closeIdle() {
+			return
+		}
 		reqstr, err := parseReq(s.r)
+		// closed by drain while waiting
+		if !atomic.CompareAndSwapInt32(&s.state, sessionIdle, sessionBusy) {
+			return
+		}
 
 		//for stats
-		s.LastAccess = time.Now().UnixNano() / 1e3
+		atomic.StoreInt64(&s.LastAccess, time.Now().UnixNano()/1e3)
 		atomic.AddInt64(&s.Proxy.OpCount, 1)
 
 		req := redis.NewRequest(reqstr)
@@ -51,7 +66,15 @@ func HandleConn(ps *ProxyServer, c net.Conn) {
 			continue
 		}
 
-		reply, shouldClose, handled, err := preCheckCommand(req)
+		start := time.Now()
+		reply, shouldClose, handled, err := preCheckCommand(req, ps.Commands())
+		// health checks see the proxy leaving
+		if req.Name() == "PING" && ps.Draining() {
+			reply, err = nil, ProxyDraining
+		}
+		if err == nil && !handled && ps.Blacklist.Blocked(req.Name(), requestKeys(req)) {
+			err = KeyBlacked
+		}
 
 		// log.Info(req, reply, shouldClose, handled, err)
 
@@ -60,6 +83,7 @@ func HandleConn(ps *ProxyServer, c net.Conn) {
 
 		if err != nil || shouldClose || handled {
 			s.Write2client(req)
+			s.observe(req, start)
 			if shouldClose {
 				// log.("should close from ", c.RemoteAddr())
 				s.Close()
@@ -70,12 +94,27 @@ func HandleConn(ps *ProxyServer, c net.Conn) {
 		// spec command : mget mset  del inter union  .....
 		if isSpecCommand(req.Name()) {
 			s.SpecCommandProcess(req)
+			s.observe(req, start)
 			continue
 		}
 		s.Forward(req)
+		s.observe(req, start)
+		// after client got reply, shadow never slows client down
+		if m := s.Proxy.Mirror; m != nil {
+			m.Copy(req, req.Result())
+		}
+		if ks := s.Proxy.KeyStats; ks != nil {
+			ks.Record(req, req.Result())
+		}
 	}
 }
 
+const (
+	sessionIdle int32 = iota
+	sessionBusy
+	sessionClosed
+)
+
 type Session struct {
 	Conn net.Conn
 	r    *bufio.Reader
@@ -86,6 +125,8 @@ type Session struct {
 	LastAccess int64 // unixtime stamp
 	QuitChan   chan int
 
+	state int32 // sessionIdle, sessionBusy or sessionClosed, atomic
+
 	MulOpParallel int
 }
 
@@ -103,16 +144,51 @@ func NewSession(ps *ProxyServer, conn net.Conn) *Session {
 }
 
 func (s *Session) Forward(req *redis.Request) {
+	if c := s.Proxy.Cache; c != nil {
+		if reply, ok := c.Get(req); ok {
+			req.SetReply(reply)
+			s.Write2client(req)
+			return
+		}
+		s.forward(req)
+		c.Update(req)
+		s.Write2client(req)
+		return
+	}
 	s.forward(req)
 	s.Write2client(req)
 }
 
 func (s *Session) forward(req *redis.Request) {
-	resp := s.Proxy.Dispatch(req)
+	var resp redis.Cmder
+	if c := s.Proxy.Coalescer; c != nil {
+		resp = c.Do(req, s.Proxy.Dispatch)
+	} else {
+		resp = s.Proxy.Dispatch(req)
+	}
 	// log.Info("session forward got response: ", resp)
 	req.SetResp(resp)
 }
 
+// observe records req which took since start for metrics and statsd
+func (s *Session) observe(req *redis.Request, start time.Time) {
+	d := time.Since(start)
+	reply := req.Result()
+	failed := len(reply) > 0 && reply[0] == '-'
+	if m := s.Proxy.Metrics; m != nil {
+		m.ObserveCommand(req.Name(), d, failed)
+	}
+
+	// us like .time
+	name := commandLabel(req.Name())
+	client, rate := s.Proxy.Statsd, s.Proxy.Conf.StatsdSample
+	client.TimingSampled("cmd."+name+".time", int64(d/time.Microsecond), rate)
+	client.IncrSampled("cmd."+name+".count", 1, rate)
+	if failed {
+		client.Incr("cmd."+name+".errors", 1)
+	}
+}
+
 func (s *Session) Write2client(req *redis.Request) error {
 	return s.write2client(req.Result())
 }
@@ -137,6 +213,16 @@ func (s *Session) write2client(data []byte) error {
 	return err
 }
 
+// closeIdle closes session if it is waiting for a request, it reports
+// whether session is closed
+func (s *Session) closeIdle() bool {
+	if !atomic.CompareAndSwapInt32(&s.state, sessionIdle, sessionClosed) {
+		return false
+	}
+	s.Close()
+	return true
+}
+
 func (s *Session) Close() {
 	defer func() {
 		if e := recover(); e != nil {
@@ -0,0 +1,129 @@
+package smartproxy
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dongzerun/smartproxy/redis"
+	"github.com/dongzerun/smartproxy/statsd"
+)
+
+// fakeBackend keeps strings in memory, enough to serve GET SET DEL
+type fakeBackend struct {
+	watchers
+
+	lock sync.Mutex
+	data map[string]string
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{data: make(map[string]string)}
+}
+
+func (b *fakeBackend) Dispatch(req *redis.Request) redis.Cmder {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	args := req.Args()
+	switch req.Name() {
+	case "GET":
+		v, ok := b.data[args[0]]
+		if !ok {
+			return redis.NewStringResult("", redis.Nil)
+		}
+		return redis.NewStringResult(v, nil)
+	case "SET":
+		b.data[args[0]] = args[1]
+		return redis.NewStatusResult("OK", nil)
+	case "DEL":
+		var n int64
+		for _, key := range args {
+			if _, ok := b.data[key]; ok {
+				delete(b.data, key)
+				n++
+			}
+		}
+		return redis.NewIntResult(n, nil)
+	}
+	return redis.NewStringResult("", redis.ReflectUnvalidErr)
+}
+
+func (b *fakeBackend) Pipeline(reqs []*redis.Request) ([]redis.Cmder, error) {
+	cmds := make([]redis.Cmder, len(reqs))
+	for i, req := range reqs {
+		cmds[i] = b.Dispatch(req)
+	}
+	return cmds, nil
+}
+
+func (b *fakeBackend) Nodes() []string {
+	return []string{"fake:6379"}
+}
+
+func (b *fakeBackend) Broadcast(req *redis.Request) map[string]redis.Cmder {
+	return map[string]redis.Cmder{"fake:6379": b.Dispatch(req)}
+}
+
+func (b *fakeBackend) SetSlaveOk(ok bool) {}
+func (b *fakeBackend) Info() []string     { return []string{"fake:6379"} }
+func (b *fakeBackend) Close() error       { return nil }
+
+func (b *fakeBackend) PoolStats() map[string]redis.PoolStats { return nil }
+
+func newFakeProxy(b Backend) *ProxyServer {
+	return &ProxyServer{
+		Conf: &ProxyConfig{
+			Port:          "8889",
+			MaxConn:       MinMaxConn,
+			MulOpParallel: MinMulOpParallel,
+			BackendType:   "fake",
+			StatsdSample:  1,
+		},
+		Backend:   b,
+		Statsd:    statsd.NewClient("", ""),
+		Blacklist: &Blacklist{exact: make(map[string]*BlackEntry)},
+		Quit:      make(chan bool, 1),
+		SessMgr:   make(map[string]*Session),
+		TimeChan:  make(chan int64, 1024),
+		QpsChan:   make(chan int64, 1024),
+	}
+}
+
+func TestHandleConn(t *testing.T) {
+	ps := newFakeProxy(newFakeBackend())
+	client, server := net.Pipe()
+	defer client.Close()
+	go HandleConn(ps, server)
+
+	rd := bufio.NewReader(client)
+	tests := []struct {
+		req  []string
+		resp string
+	}{
+		{[]string{"SET", "foo", "bar"}, "+OK\r\n"},
+		{[]string{"GET", "foo"}, "$3\r\nbar\r\n"},
+		{[]string{"GET", "nokey"}, "$-1\r\n"},
+		{[]string{"MGET", "foo", "nokey"}, "*2\r\n$3\r\nbar\r\n$-1\r\n"},
+		{[]string{"DEL", "foo", "nokey"}, ":1\r\n"},
+		{[]string{"GET", "foo"}, "$-1\r\n"},
+	}
+	for _, test := range tests {
+		if _, err := client.Write(redis.FormatStringSlice(test.req)); err != nil {
+			t.Fatal(err)
+		}
+		var resp []string
+		for n := strings.Count(test.resp, "\n"); n > 0; n-- {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp = append(resp, line)
+		}
+		if got := strings.Join(resp, ""); got != test.resp {
+			t.Errorf("%v got %q, want %q", test.req, got, test.resp)
+		}
+	}
+}
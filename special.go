@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/dongzerun/smartproxy/redis"
 	"sync"
+	"sync/atomic"
 
 	log "github.com/ngaut/logging"
 )
@@ -11,6 +12,11 @@ import (
 func (s *Session) SpecCommandProcess(req *redis.Request) {
 	// log.Info("Spec command Process ", req)
 
+	// A read racing with the write may cache the old value again before
+	// the write reaches backend, so keys are invalidated after it too.
+	s.invalidateSpec(req)
+	defer s.invalidateSpec(req)
+
 	switch req.Name() {
 	case "SINTERSTORE":
 		s.SINTERSTORE(req)
@@ -42,11 +48,23 @@ func (s *Session) SpecCommandProcess(req *redis.Request) {
 		s.MSETNX(req)
 	case "PROXY":
 		s.PROXY(req)
+	case "CLUSTER":
+		s.CLUSTER(req)
 	default:
 		log.Fatalf("Unknown Spec Command: %s, we won't expect this happen ", req.Name())
 	}
 }
 
+// invalidateSpec drops cached keys written by req, args may be keys,
+// values are invalidated too which is harmless
+func (s *Session) invalidateSpec(req *redis.Request) {
+	if c := s.Proxy.Cache; c != nil && redis.IsWrite(req.Name()) {
+		for _, key := range req.Args() {
+			c.Invalidate(key)
+		}
+	}
+}
+
 //we will finish these commands later
 func (s *Session) MSETNX(req *redis.Request)      { s.write2client(OK_BYTES) }
 func (s *Session) ZUNIONSTORE(req *redis.Request) { s.write2client(OK_BYTES) }
@@ -78,7 +96,7 @@ func (s *Session) MSET(req *redis.Request) {
 	}()
 	wg := sync.WaitGroup{}
 	wg.Add(len(pair) / 2)
-	partialErr := 0
+	var partialErr int64
 	// we just ignore return code, MSET reuturn OK unless anyone set error
 	for i := 0; i < len(pair); i += 2 {
 		go func(k string, v string) {
@@ -86,10 +104,10 @@ func (s *Session) MSET(req *redis.Request) {
 			// log.Info("In MSET goroutine ", k, v)
 			cmdslice := []string{"SET", k, v}
 			r := redis.NewRequest(cmdslice)
-			resp := s.Proxy.Backend.OnSET(r)
+			resp := s.Proxy.Dispatch(r)
 			if resp.Err() != nil && resp.Err() != redis.Nil {
 				// log.Warning("MSET error ", cmdslice, resp.Err())
-				partialErr += 1
+				atomic.AddInt64(&partialErr, 1)
 			}
 			p <- 1
 			wg.Done()
@@ -100,7 +118,7 @@ func (s *Session) MSET(req *redis.Request) {
 	if partialErr == 0 {
 		s.write2client(OK_BYTES)
 	} else {
-		d := fmt.Sprintf("- %d MSET failed, partial key/value %d set\r\n", partialErr, len(pair)/2-partialErr)
+		d := fmt.Sprintf("- %d MSET failed, partial key/value %d set\r\n", partialErr, int64(len(pair)/2)-partialErr)
 		s.write2client([]byte(d))
 	}
 }
@@ -128,7 +146,7 @@ func (s *Session) MGET(req *redis.Request) {
 			// log.Info("In MGET goroutine ", key)
 			cmdslice := []string{"GET", key}
 			r := redis.NewRequest(cmdslice)
-			resp := s.Proxy.Backend.OnGET(r)
+			resp := s.Proxy.Dispatch(r)
 			result[idx] = resp.Reply()
 			p <- 1
 			wg.Done()
@@ -167,8 +185,10 @@ func (s *Session) DEL(req *redis.Request) {
 			// log.Info("In DEL goroutine ", key)
 			cmdslice := []string{"DEL", key}
 			r := redis.NewRequest(cmdslice)
-			resp := s.Proxy.Backend.OnDEL(r)
-			result += resp.Val()
+			resp := s.Proxy.Dispatch(r)
+			if n, ok := resp.(*redis.IntCmd); ok {
+				atomic.AddInt64(&result, n.Val())
+			}
 			p <- 1
 			wg.Done()
 		}(key)
@@ -4,6 +4,7 @@ import (
 	"math"
 	"runtime"
 	"sort"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -57,16 +58,11 @@ quit:
 }
 
 func (p *ProxyServer) QpsSend() {
+	client := p.Statsd
 	for {
-		client := statsd.NewClient(p.Conf.Statsd, p.Conf.StatsdPrefix)
-		err := client.CreateSocket()
-		if err != nil {
-			// log.Warningf("ERROR: failed to create UDP socket to statsd(%s)", client)
-			continue
-		}
 		select {
 		case t := <-p.TimeChan:
-			client.Timing(".time", t)
+			client.TimingSampled(".time", t, p.Conf.StatsdSample)
 			// log.Info("get response rtt ", t)
 
 		case qps := <-p.QpsChan:
@@ -75,26 +71,163 @@ func (p *ProxyServer) QpsSend() {
 		case <-p.Quit:
 			goto quit
 		}
-		client.Close()
 	}
 quit:
 	log.Warning("quit Qps Send loop")
 }
 
-func (p *ProxyServer) StatsdMemStats() {
+// statsdStats sends counters every 10 seconds as increments since last
+// tick, and gauges as they are, names prefixed. gauges may be nil.
+func (p *ProxyServer) statsdStats(prefix string, counters, gauges func() map[string]int64) {
 	ticker := time.NewTicker(10 * time.Second)
-	var lastMemStats runtime.MemStats
+	defer ticker.Stop()
+	last := make(map[string]int64)
 
 	for {
 		select {
 		case <-ticker.C:
-			client := statsd.NewClient(p.Conf.Statsd, p.Conf.StatsdPrefix)
-			err := client.CreateSocket()
-			if err != nil {
-				// log.Warningf("ERROR: failed to create UDP socket to statsd(%s)", client)
+			client := p.Statsd
+			stats := counters()
+			for name, n := range stats {
+				if n != last[name] {
+					client.Incr(prefix+name, n-last[name])
+				}
+			}
+			last = stats
+			if gauges == nil {
 				continue
 			}
+			for name, n := range gauges() {
+				client.Gauge(prefix+name, n)
+			}
+		case <-p.Quit:
+			log.Warning("quit statsd loop of ", strings.TrimSuffix(prefix, "."))
+			return
+		}
+	}
+}
+
+// StatsdBackendStats sends TRYAGAIN CLUSTERDOWN LOADING retries and
+// breaker state(0 closed, 1 half-open, 2 open) per node
+func (p *ProxyServer) StatsdBackendStats() {
+	backend, ok := p.Backend.(*clusterBackend)
+	if !ok {
+		// only cluster backend retries and has breakers
+		return
+	}
+	p.statsdStats("", func() map[string]int64 {
+		stats := make(map[string]int64)
+		for addr, n := range backend.RetryStats() {
+			stats["retry."+statsd.HostKey(addr)] = n
+		}
+		return stats
+	}, func() map[string]int64 {
+		stats := make(map[string]int64)
+		for addr, state := range backend.BreakerStats() {
+			stats["breaker."+statsd.HostKey(addr)] = int64(state)
+		}
+		return stats
+	})
+}
+
+// StatsdMigrateStats sends secondary writes, errors, mismatches,
+// dropped writes and read fallbacks of dual write migration
+func (p *ProxyServer) StatsdMigrateStats() {
+	if p.Migrate == nil {
+		return
+	}
+	p.statsdStats("migrate.", p.Migrate.Stats, nil)
+}
+
+// StatsdMirrorStats sends copies sent to shadow, dropped, failed and
+// reply mismatches per command
+func (p *ProxyServer) StatsdMirrorStats() {
+	if p.Mirror == nil {
+		return
+	}
+	p.statsdStats("mirror.", p.Mirror.Stats, nil)
+}
+
+// StatsdCacheStats sends hits, misses and evictions of hot key cache,
+// entries and bytes as gauges
+func (p *ProxyServer) StatsdCacheStats() {
+	if p.Cache == nil {
+		return
+	}
+	pick := func(names ...string) func() map[string]int64 {
+		return func() map[string]int64 {
+			all := p.Cache.Stats()
+			stats := make(map[string]int64, len(names))
+			for _, name := range names {
+				stats[name] = all[name]
+			}
+			return stats
+		}
+	}
+	p.statsdStats("cache.", pick("hits", "misses", "evictions"), pick("entries", "bytes"))
+}
+
+// StatsdCoalesceStats sends backend calls of coalescable commands,
+// requests coalesced and requests overflowed full flights
+func (p *ProxyServer) StatsdCoalesceStats() {
+	if p.Coalescer == nil {
+		return
+	}
+	p.statsdStats("coalesce.", p.Coalescer.Stats, nil)
+}
 
+// StatsdEvents sends cluster topology events by type
+func (p *ProxyServer) StatsdEvents() {
+	p.statsdStats("events.", p.Events.Stats, nil)
+}
+
+// statsdKeyStatsTop is how many hot keys and big keys are sent
+const statsdKeyStatsTop = 10
+
+// StatsdKeyStats sends qps of hot keys and reply size of big keys as gauges
+func (p *ProxyServer) StatsdKeyStats() {
+	if p.KeyStats == nil {
+		return
+	}
+	ticker := time.NewTicker(10 * time.Second)
+
+	for {
+		select {
+		case <-ticker.C:
+			client := p.Statsd
+			for _, k := range p.KeyStats.HotKeys(statsdKeyStatsTop) {
+				client.Gauge("hotkeys."+statsdName(k.Key), k.Value)
+			}
+			for _, k := range p.KeyStats.BigKeys(statsdKeyStatsTop) {
+				client.Gauge("bigkeys."+k.Cmd+"."+statsdName(k.Key), k.Value)
+			}
+		case <-p.Quit:
+			goto quit
+		}
+	}
+quit:
+	log.Warning("quit StatsdKeyStats loop")
+}
+
+// statsdName replaces chars having meaning in statsd protocol or metric path
+func statsdName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ':', '|', '@', ' ', '\n', '\r':
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+func (p *ProxyServer) StatsdMemStats() {
+	ticker := time.NewTicker(10 * time.Second)
+	var lastMemStats runtime.MemStats
+
+	for {
+		select {
+		case <-ticker.C:
+			client := p.Statsd
 			var memStats runtime.MemStats
 			runtime.ReadMemStats(&memStats)
 
@@ -118,7 +251,6 @@ func (p *ProxyServer) StatsdMemStats() {
 			client.Incr("mem.gc_runs", int64(memStats.NumGC-lastMemStats.NumGC))
 
 			lastMemStats = memStats
-			client.Close()
 		case <-p.Quit:
 			goto quit
 		}
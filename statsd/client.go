@@ -1,63 +1,255 @@
 package statsd
 
 import (
-	"errors"
-	"fmt"
+	"math/rand"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	// packets fit ethernet MTU without ip and udp headers
+	DefaultPacketSize = 1432
+	// buffered metrics are sent at least this often
+	DefaultFlushInterval = time.Second
+	// failed dials are retried at most this often
+	redialInterval = time.Second
+)
+
+// Client sends metrics over one udp socket, metrics are buffered and
+// sent in packets of packetSize or every flushInterval. Tags are in
+// DogStatsD format, plain statsd ignores them if tags are not set.
+// Socket is dialed by flusher only, and no io is done under lock, so a
+// slow dns or statsd never blocks callers.
 type Client struct {
-	conn   net.Conn
-	addr   string
 	prefix string
+	tags   string // global tags, like "env:prod,dc:bj"
+
+	lock     sync.Mutex
+	addr     string
+	conn     net.Conn
+	lastDial time.Time
+	buf      []byte
+	closed   bool
+
+	dial chan struct{} // wakes flusher to dial
+	quit chan struct{}
+	done chan struct{} // flusher sent last packet
+	once sync.Once
 }
 
-func NewClient(addr string, prefix string) *Client {
-	return &Client{
-		addr:   addr,
+// NewClient returns client sending to addr, empty addr disables it.
+// Socket is created on first metric.
+func NewClient(addr string, prefix string, tags ...string) *Client {
+	c := &Client{
 		prefix: prefix,
+		tags:   strings.Join(tags, ","),
+		addr:   addr,
+		buf:    make([]byte, 0, DefaultPacketSize),
+		dial:   make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
 	}
+	go c.flusher()
+	return c
 }
 
 func (c *Client) String() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	return c.addr
 }
 
-func (c *Client) CreateSocket() error {
-	conn, err := net.DialTimeout("udp", c.addr, time.Second)
-	if err != nil {
-		return err
+// SetAddr sends metrics to addr from now on, buffered ones are sent to
+// the old addr first
+func (c *Client) SetAddr(addr string) {
+	c.lock.Lock()
+	if addr == c.addr {
+		c.lock.Unlock()
+		return
+	}
+	packet, conn := c.take()
+	c.conn = nil
+	c.addr = addr
+	c.lastDial = time.Time{}
+	c.lock.Unlock()
+
+	if conn != nil {
+		conn.Write(packet)
+		conn.Close()
 	}
-	c.conn = conn
-	return nil
+	c.wake()
 }
 
+// Close sends buffered metrics and closes socket
 func (c *Client) Close() error {
-	return c.conn.Close()
+	c.once.Do(func() { close(c.quit) })
+	<-c.done
+
+	c.lock.Lock()
+	packet, conn := c.take()
+	c.conn = nil
+	c.closed = true
+	c.lock.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	conn.Write(packet)
+	return conn.Close()
 }
 
-func (c *Client) Incr(stat string, count int64) error {
-	return c.send(stat, "%d|c", count)
+func (c *Client) Incr(stat string, count int64, tags ...string) {
+	c.send(stat, count, "c", 1, tags)
 }
 
-func (c *Client) Decr(stat string, count int64) error {
-	return c.send(stat, "%d|c", -count)
+func (c *Client) Decr(stat string, count int64, tags ...string) {
+	c.send(stat, -count, "c", 1, tags)
 }
 
-func (c *Client) Timing(stat string, delta int64) error {
-	return c.send(stat, "%d|ms", delta)
+func (c *Client) Timing(stat string, delta int64, tags ...string) {
+	c.send(stat, delta, "ms", 1, tags)
 }
 
-func (c *Client) Gauge(stat string, value int64) error {
-	return c.send(stat, "%d|g", value)
+func (c *Client) Gauge(stat string, value int64, tags ...string) {
+	c.send(stat, value, "g", 1, tags)
+}
+
+// IncrSampled sends count with probability rate, statsd scales it back
+func (c *Client) IncrSampled(stat string, count int64, rate float64, tags ...string) {
+	c.send(stat, count, "c", rate, tags)
+}
+
+// TimingSampled sends delta with probability rate
+func (c *Client) TimingSampled(stat string, delta int64, rate float64, tags ...string) {
+	c.send(stat, delta, "ms", rate, tags)
+}
+
+// send formats metric as prefix.stat:value|type|@rate|#tags
+func (c *Client) send(stat string, value int64, typ string, rate float64, tags []string) {
+	if rate < 1 && rand.Float64() >= rate {
+		return
+	}
+
+	line := make([]byte, 0, 64)
+	line = append(line, c.prefix...)
+	line = append(line, stat...)
+	line = append(line, ':')
+	line = strconv.AppendInt(line, value, 10)
+	line = append(line, '|')
+	line = append(line, typ...)
+	if rate < 1 {
+		line = append(line, "|@"...)
+		line = strconv.AppendFloat(line, rate, 'f', -1, 64)
+	}
+	if c.tags != "" || len(tags) > 0 {
+		line = append(line, "|#"...)
+		line = append(line, c.tags...)
+		for i, tag := range tags {
+			if i > 0 || c.tags != "" {
+				line = append(line, ',')
+			}
+			line = append(line, tag...)
+		}
+	}
+
+	var packet []byte
+	var conn net.Conn
+	c.lock.Lock()
+	if c.addr == "" {
+		c.lock.Unlock()
+		return
+	}
+	if len(c.buf) > 0 && len(c.buf)+1+len(line) > DefaultPacketSize {
+		packet, conn = c.take()
+	}
+	if len(c.buf) > 0 {
+		c.buf = append(c.buf, '\n')
+	}
+	c.buf = append(c.buf, line...)
+	dial := c.conn == nil
+	c.lock.Unlock()
+
+	send(packet, conn)
+	if dial {
+		c.wake()
+	}
+}
+
+// take returns buffered packet and socket to send it, c.lock must be
+// held.
+func (c *Client) take() ([]byte, net.Conn) {
+	if len(c.buf) == 0 {
+		return nil, c.conn
+	}
+	packet := c.buf
+	c.buf = make([]byte, 0, DefaultPacketSize)
+	return packet, c.conn
+}
+
+// send writes packet, metrics are dropped if socket is not available.
+func send(packet []byte, conn net.Conn) {
+	if len(packet) > 0 && conn != nil {
+		conn.Write(packet)
+	}
+}
+
+// wake asks flusher to dial.
+func (c *Client) wake() {
+	select {
+	case c.dial <- struct{}{}:
+	default:
+	}
+}
+
+// redial creates socket if there is none, only flusher calls it.
+func (c *Client) redial() {
+	c.lock.Lock()
+	addr := c.addr
+	// never spin on a down statsd
+	if addr == "" || c.conn != nil || c.closed || time.Since(c.lastDial) < redialInterval {
+		c.lock.Unlock()
+		return
+	}
+	c.lastDial = time.Now()
+	c.lock.Unlock()
+
+	conn, err := net.DialTimeout("udp", addr, time.Second)
+	if err != nil {
+		return
+	}
+	c.lock.Lock()
+	if c.addr == addr && c.conn == nil && !c.closed {
+		c.conn, conn = conn, nil
+	}
+	c.lock.Unlock()
+	// addr changed or closed meanwhile
+	if conn != nil {
+		conn.Close()
+	}
 }
 
-func (c *Client) send(stat string, format string, value int64) error {
-	if c.conn == nil {
-		return errors.New("not connected")
+func (c *Client) flusher() {
+	ticker := time.NewTicker(DefaultFlushInterval)
+	defer ticker.Stop()
+	defer close(c.done)
+	for {
+		quit := false
+		select {
+		case <-ticker.C:
+		case <-c.dial:
+		case <-c.quit:
+			quit = true
+		}
+		c.redial()
+		c.lock.Lock()
+		packet, conn := c.take()
+		c.lock.Unlock()
+		send(packet, conn)
+		if quit {
+			return
+		}
 	}
-	format = fmt.Sprintf("%s%s:%s", c.prefix, stat, format)
-	_, err := fmt.Fprintf(c.conn, format, value)
-	return err
 }
@@ -0,0 +1,179 @@
+package smartproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/ngaut/logging"
+)
+
+const (
+	// fds of listeners passed to new process by Upgrade
+	listenerFdEnv = "SMARTPROXY_LISTENER_FD"
+	adminFdEnv    = "SMARTPROXY_ADMIN_FD"
+	// new process writes it after Init, old one drains then
+	readyFdEnv = "SMARTPROXY_READY_FD"
+
+	// new process must be ready within it, or it is killed
+	upgradeTimeout = 30 * time.Second
+)
+
+var UpgradeInProgress = errors.New("upgrade in progress")
+
+// Upgrade starts os.Args[0] again, usually a new binary at the same
+// path, and passes listeners to it. Clients keep connecting to the same
+// socket, no connection is refused. It returns pid of new process once
+// it is ready, caller should drain this process then.
+func (ps *ProxyServer) Upgrade() (int, error) {
+	if ps.Draining() {
+		return 0, ProxyDraining
+	}
+	if !atomic.CompareAndSwapInt32(&ps.upgrading, 0, 1) {
+		return 0, UpgradeInProgress
+	}
+	pid, err := ps.upgrade()
+	if err != nil {
+		atomic.StoreInt32(&ps.upgrading, 0)
+		log.Warning("upgrade failed ", err)
+		return 0, err
+	}
+	log.Warningf("upgraded to pid %d", pid)
+	// new process serves admin api from now on, like /health
+	if l := ps.adminListener(); l != nil {
+		l.Close()
+	}
+	return pid, nil
+}
+
+func (ps *ProxyServer) upgrade() (int, error) {
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	var fds []int
+	defer func() {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+	}()
+	env := os.Environ()
+	files := []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}
+	pass := func(name string, fd int) {
+		env = append(env, fmt.Sprintf("%s=%d", name, len(files)))
+		files = append(files, uintptr(fd))
+	}
+
+	fd, err := listenerFd(ps.Listen)
+	if err != nil {
+		return 0, err
+	}
+	fds = append(fds, fd)
+	pass(listenerFdEnv, fd)
+	if l := ps.adminListener(); l != nil {
+		fd, err := listenerFd(l)
+		if err != nil {
+			return 0, err
+		}
+		fds = append(fds, fd)
+		pass(adminFdEnv, fd)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	pass(readyFdEnv, int(w.Fd()))
+
+	pid, err := syscall.ForkExec(path, os.Args, &syscall.ProcAttr{Env: env, Files: files})
+	// read sees EOF if new process quits, our w must be closed for it
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return 0, err
+	}
+	// reaped if it quits before us
+	go p.Wait()
+
+	r.SetReadDeadline(time.Now().Add(upgradeTimeout))
+	if _, err := r.Read(make([]byte, 1)); err != nil {
+		p.Kill()
+		return 0, fmt.Errorf("new process %d not ready, %s", pid, err)
+	}
+	return pid, nil
+}
+
+func (ps *ProxyServer) adminListener() net.Listener {
+	ps.Lock.Lock()
+	defer ps.Lock.Unlock()
+	return ps.adminListen
+}
+
+// listenerFd dups fd of l, os.File of l is not used as its Fd makes
+// socket blocking, and Close of l would wait for Accept forever
+func listenerFd(l net.Listener) (int, error) {
+	sc, ok := l.(syscall.Conn)
+	if !ok {
+		return -1, fmt.Errorf("can not pass listener %T", l)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd, derr := -1, error(nil)
+	err = rc.Control(func(s uintptr) {
+		fd, derr = syscall.Dup(int(s))
+		if derr == nil {
+			syscall.CloseOnExec(fd)
+		}
+	})
+	if err != nil {
+		return -1, err
+	}
+	return fd, derr
+}
+
+// inheritedListener returns listener passed by Upgrade of old process,
+// nil if there is none
+func inheritedListener(env string) (net.Listener, error) {
+	v := os.Getenv(env)
+	if v == "" {
+		return nil, nil
+	}
+	// not passed to our own new process
+	os.Unsetenv(env)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("bad %s %q", env, v)
+	}
+	f := os.NewFile(uintptr(fd), env)
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// notifyReady tells old process started us that we are serving
+func notifyReady() {
+	v := os.Getenv(readyFdEnv)
+	if v == "" {
+		return
+	}
+	os.Unsetenv(readyFdEnv)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		log.Warningf("bad %s %q", readyFdEnv, v)
+		return
+	}
+	f := os.NewFile(uintptr(fd), readyFdEnv)
+	f.Write([]byte{1})
+	f.Close()
+}
@@ -0,0 +1,83 @@
+package smartproxy
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dongzerun/smartproxy/redis"
+)
+
+// TestUpgradeChild is the new process started by TestUpgrade, it serves
+// on the inherited listener until killed. It does nothing in go test.
+func TestUpgradeChild(t *testing.T) {
+	if os.Getenv(listenerFdEnv) == "" {
+		return
+	}
+	b := newFakeBackend()
+	b.data["who"] = "child"
+	ps := newFakeProxy(b)
+	ps.Init()
+	go ps.Run()
+	time.Sleep(upgradeTimeout)
+}
+
+func TestUpgrade(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newFakeBackend()
+	b.data["who"] = "parent"
+	ps := newFakeProxy(b)
+	ps.Listen = l
+	ps.Conf.GraceTime = 1
+	stopped := make(chan struct{})
+	go func() {
+		ps.Run()
+		close(stopped)
+	}()
+
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestUpgradeChild$"}
+	pid, err := ps.Upgrade()
+	os.Args = args
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Upgrade returns after the ready pipe is written
+	if p, err := os.FindProcess(pid); err == nil {
+		defer p.Kill()
+	}
+
+	ps.Drain()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("old process still accepting after Drain")
+	}
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Write(redis.FormatStringSlice([]string{"GET", "who"})); err != nil {
+		t.Fatal(err)
+	}
+	rd := bufio.NewReader(c)
+	var resp string
+	for i := 0; i < 2; i++ {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp += line
+	}
+	if want := "$5\r\nchild\r\n"; resp != want {
+		t.Fatalf("GET who on inherited listener got %q, want %q", resp, want)
+	}
+}
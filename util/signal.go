@@ -8,10 +8,23 @@ import (
 	log "github.com/ngaut/logging"
 )
 
-func RegisterSignalAndWait() {
+// RegisterSignalAndWait waits for SIGINT or SIGTERM and returns it,
+// signals in hooks call their hook instead, like SIGHUP to reload config
+func RegisterSignalAndWait(hooks map[os.Signal]func()) os.Signal {
+	sigs := []os.Signal{os.Interrupt, syscall.SIGINT, syscall.SIGTERM}
+	for sig := range hooks {
+		sigs = append(sigs, sig)
+	}
 	sc := make(chan os.Signal, 1)
-	signal.Notify(sc, os.Interrupt, syscall.SIGINT)
+	signal.Notify(sc, sigs...)
 
-	quit := <-sc
-	log.Warning("Receive signal ", quit.String())
+	for {
+		sig := <-sc
+		log.Warning("Receive signal ", sig.String())
+		if hook, ok := hooks[sig]; ok {
+			hook()
+			continue
+		}
+		return sig
+	}
 }